@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ppriyankuu/goback/internals/backup"
+	goback "github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/fs"
+	"github.com/ppriyankuu/goback/internals/storage"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// destinationFlag is the flag naming the directory backups are stored in.
+func destinationFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "destination",
+		Aliases: []string{"d"},
+		Usage:   "Destination directory for backups",
+	}
+}
+
+// identityFlag is the flag naming the private key for recipient-encrypted archives.
+func identityFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "identity",
+		Aliases: []string{"k"},
+		Usage:   "Private key file used to read archives encrypted to recipients",
+	}
+}
+
+// backupCommand defines the "backup" command creating a new snapshot.
+func backupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Back up a directory into the destination",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "source", // Source directory to backup
+				Aliases: []string{"s"},
+				Usage:   "Source directory to back up",
+			},
+			destinationFlag(),
+			&cli.BoolFlag{
+				Name:    "incremental", // Toggle for incremental backup
+				Aliases: []string{"i"},
+				Usage:   "Enable incremental backup",
+			},
+			&cli.BoolFlag{
+				Name:    "differential", // Toggle for differential backup
+				Aliases: []string{"D"},
+				Usage:   "Back up everything changed since the last full backup",
+			},
+			&cli.StringFlag{
+				Name:    "format", // Archive format for new backups
+				Aliases: []string{"f"},
+				Usage:   "Archive format: zip, tar, tar.gz or tar.zst (default: from config, else zip)",
+			},
+			&cli.StringFlag{
+				Name:    "mode", // Storage mode for new backups
+				Aliases: []string{"m"},
+				Usage:   "Storage mode: archive or repository (default: from config, else archive)",
+			},
+			&cli.BoolFlag{
+				Name:    "follow-symlinks", // Back up link targets
+				Aliases: []string{"L"},
+				Usage:   "Back up the targets of symbolic links instead of the links themselves",
+			},
+		},
+		Before:       requireFlags("source", "destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Pick the kind of backup.
+			snapshotType := storage.SnapshotFull
+			switch {
+			case c.Bool("incremental") && c.Bool("differential"):
+				return cli.Exit("--incremental and --differential cannot be combined", exitUsage)
+			case c.Bool("incremental"):
+				snapshotType = storage.SnapshotIncremental
+			case c.Bool("differential"):
+				snapshotType = storage.SnapshotDifferential
+			}
+
+			return backup.Backup(c.String("source"), c.String("destination"), snapshotType,
+				c.String("config"), c.String("format"), c.String("mode"), c.Bool("follow-symlinks"))
+		},
+	}
+}
+
+// restoreCommand defines the "restore" command reconstructing a snapshot.
+func restoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "Restore a snapshot (default: the most recent)",
+		ArgsUsage: "[path...]",
+		Flags: []cli.Flag{
+			destinationFlag(),
+			identityFlag(),
+			&cli.StringFlag{
+				Name:  "snapshot", // Snapshot to restore
+				Usage: "ID (or unique ID prefix) of the snapshot to restore",
+			},
+			&cli.StringFlag{
+				Name:  "as-of", // Point in time to restore
+				Usage: "Restore the newest snapshot taken at or before this time, e.g. \"2026-10-01 12:00\"",
+			},
+			&cli.StringFlag{
+				Name:    "source", // Source whose snapshot to restore
+				Aliases: []string{"s"},
+				Usage:   "Pick the latest or --as-of snapshot among the backups of this source directory",
+			},
+			&cli.StringSliceFlag{
+				Name:  "include", // Patterns of entries to restore
+				Usage: "Restore only entries matching this pattern, e.g. 'etc/nginx/**' (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude", // Patterns of entries to leave out
+				Usage: "Do not restore entries matching this pattern, e.g. '*.log' (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:    "target", // Directory to restore into
+				Aliases: []string{"t"},
+				Usage:   "Directory to restore into, apart from the destination (required)",
+			},
+			&cli.IntFlag{
+				Name:  "strip-components", // Leading components to drop
+				Usage: "Remove this many leading components from restored paths",
+			},
+			&cli.StringSliceFlag{
+				Name:  "map", // Prefixes to rename
+				Usage: "Restore entries below old under new instead, e.g. 'config=etc/app' (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:  "on-conflict", // What to do with existing entries
+				Usage: "What to do with entries that already exist: overwrite, skip, newer, rename or fail",
+				Value: "overwrite",
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run", // Preview without writing
+				Aliases: []string{"n"},
+				Usage:   "Print what would be created, overwritten, skipped or deleted without changing anything",
+			},
+		},
+		Before:       requireFlags("destination", "target"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			return backup.Restore(c.String("destination"), c.String("config"), c.String("identity"),
+				c.String("snapshot"), c.String("as-of"), backup.RestoreOptions{
+					Target:          c.String("target"),
+					Source:          c.String("source"),
+					StripComponents: c.Int("strip-components"),
+					Map:             c.StringSlice("map"),
+					Paths:           c.Args().Slice(),
+					Include:         c.StringSlice("include"),
+					Exclude:         c.StringSlice("exclude"),
+					OnConflict:      c.String("on-conflict"),
+					DryRun:          c.Bool("dry-run"),
+				})
+		},
+	}
+}
+
+// snapshotsCommand defines the "snapshots" command listing the catalog.
+func snapshotsCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "snapshots",
+		Usage:        "List the snapshots in the destination",
+		Flags:        []cli.Flag{destinationFlag()},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Read the catalog.
+			snapshots, err := backup.ListSnapshots(c.String("destination"))
+			if err != nil {
+				return err
+			}
+
+			// Print one row per snapshot, oldest first.
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tTYPE\tTIME\tHOST\tFILES\tSIZE\tSOURCE")
+			for _, s := range snapshots {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", s.ID, s.Type, s.StartTime.Format(time.DateTime),
+					s.Host, s.FileCount, goback.FormatBytes(s.Bytes), s.Source)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// lsCommand defines the "ls" command listing the entries of a snapshot.
+func lsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "ls",
+		Usage:     "List the files of a snapshot (default: the most recent) at and below a path",
+		ArgsUsage: "[snapshot] [path]",
+		Flags: []cli.Flag{
+			destinationFlag(),
+			identityFlag(),
+			&cli.BoolFlag{
+				Name:    "long", // Long listing format
+				Aliases: []string{"l"},
+				Usage:   "Show the mode, size and modification time of every entry",
+			},
+		},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Ensure at most a snapshot and a path are given.
+			if c.NArg() > 2 {
+				return cli.Exit("expected at most a snapshot and a path", exitUsage)
+			}
+
+			// Read the entries of the snapshot from its manifest.
+			_, entries, err := backup.SnapshotEntries(c.String("destination"), c.String("config"),
+				c.String("identity"), c.Args().Get(0), c.Args().Get(1))
+			if err != nil {
+				return err
+			}
+
+			// Print every entry, marking directories with a trailing slash.
+			for _, entry := range entries {
+				name := entry.Name
+				if entry.Type == fs.TypeDir {
+					name += "/"
+				}
+				if !c.Bool("long") {
+					fmt.Println(name)
+					continue
+				}
+
+				// The long format adds the mode, size and time, and link targets.
+				switch entry.Type {
+				case fs.TypeSymlink:
+					name += " -> " + entry.Linkname
+				case fs.TypeHardlink:
+					name += " => " + entry.Linkname
+				}
+				fmt.Printf("%s %12d %s %s\n", entry.FileMode(), entry.Size, entry.ModTime.Local().Format(time.DateTime), name)
+			}
+			return nil
+		},
+	}
+}
+
+// findCommand defines the "find" command searching every snapshot.
+func findCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "find",
+		Usage:        "Find the entries matching a pattern in every snapshot",
+		ArgsUsage:    "<pattern>",
+		Flags:        []cli.Flag{destinationFlag(), identityFlag()},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Ensure exactly one pattern is given.
+			if c.NArg() != 1 {
+				return cli.Exit("expected one pattern", exitUsage)
+			}
+
+			// Search the manifests.
+			matches, err := backup.Find(c.String("destination"), c.String("config"), c.String("identity"), c.Args().First())
+			if err != nil {
+				return err
+			}
+
+			// Print one row per name with the snapshots holding it, oldest first.
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSNAPSHOTS")
+			for _, match := range matches {
+				ids := make([]string, len(match.Snapshots))
+				for i, snapshot := range match.Snapshots {
+					ids[i] = snapshot.ID
+				}
+				fmt.Fprintf(w, "%s\t%s\n", match.Name, strings.Join(ids, " "))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// catCommand defines the "cat" command printing a file of a snapshot.
+func catCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "cat",
+		Usage:        "Write a file of a snapshot to standard output, once checked against its manifest",
+		ArgsUsage:    "<snapshot> <path>",
+		Flags:        []cli.Flag{destinationFlag(), identityFlag()},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Ensure a snapshot and a path are given.
+			if c.NArg() != 2 {
+				return cli.Exit("expected a snapshot and a path", exitUsage)
+			}
+			return backup.Cat(c.String("destination"), c.String("config"), c.String("identity"),
+				c.Args().Get(0), c.Args().Get(1), os.Stdout)
+		},
+	}
+}
+
+// verifyCommand defines the "verify" command checking snapshot integrity.
+func verifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "verify",
+		Usage:        "Verify snapshots against their checksums and manifests (default: all)",
+		ArgsUsage:    "[snapshot...]",
+		Flags:        []cli.Flag{destinationFlag(), identityFlag()},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			err := backup.Verify(c.String("destination"), c.String("config"), c.String("identity"), c.Args().Slice())
+			if errors.Is(err, backup.ErrVerificationFailed) {
+				return cli.Exit(err.Error(), exitVerifyFailed)
+			}
+			return err
+		},
+	}
+}
+
+// forgetCommand defines the "forget" command removing snapshots.
+func forgetCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "forget",
+		Usage:        "Remove snapshots and their archives",
+		ArgsUsage:    "<snapshot...>",
+		Flags:        []cli.Flag{destinationFlag()},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Ensure at least one snapshot is given.
+			if c.NArg() == 0 {
+				return cli.Exit("expected at least one snapshot", exitUsage)
+			}
+			return backup.Forget(c.String("destination"), c.Args().Slice())
+		},
+	}
+}
+
+// pruneCommand defines the "prune" command applying the retention policy.
+func pruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "Remove expired snapshots and unreferenced repository data",
+		Flags: []cli.Flag{
+			destinationFlag(),
+			&cli.IntFlag{
+				Name:  "keep-days", // Retention period overriding the config
+				Usage: "Keep snapshots younger than this many days, 0 keeps all (default: retention_days from config)",
+			},
+		},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Use the configured retention unless one is given.
+			keepDays := -1
+			if c.IsSet("keep-days") {
+				keepDays = c.Int("keep-days")
+			}
+			return backup.Prune(c.String("destination"), c.String("config"), keepDays)
+		},
+	}
+}
+
+// diffCommand defines the "diff" command comparing snapshots.
+func diffCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "Compare a snapshot with a later one, or with the source directory",
+		ArgsUsage: "<snapshot> [snapshot]",
+		Flags: []cli.Flag{
+			destinationFlag(),
+			identityFlag(),
+			&cli.StringFlag{
+				Name:    "source", // Directory to compare against
+				Aliases: []string{"s"},
+				Usage:   "Source directory to compare against when only one snapshot is given",
+			},
+		},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Ensure one or two snapshots are given.
+			if c.NArg() < 1 || c.NArg() > 2 {
+				return cli.Exit("expected one or two snapshots", exitUsage)
+			}
+
+			// Classify the differences.
+			changes, err := backup.Diff(c.String("destination"), c.String("config"), c.String("identity"),
+				c.Args().Get(0), c.Args().Get(1), c.String("source"))
+			if err != nil {
+				return err
+			}
+
+			// Print one line per difference.
+			for _, name := range changes.Added {
+				fmt.Println("+ " + name)
+			}
+			for _, name := range changes.Modified {
+				fmt.Println("M " + name)
+			}
+			for _, name := range changes.Deleted {
+				fmt.Println("- " + name)
+			}
+			return nil
+		},
+	}
+}
+
+// consolidateCommand defines the "consolidate" command building synthetic full snapshots.
+func consolidateCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "consolidate",
+		Usage:     "Merge a snapshot and its chain into a new full snapshot (default: the most recent)",
+		ArgsUsage: "[snapshot]",
+		Flags: []cli.Flag{
+			destinationFlag(),
+			identityFlag(),
+			&cli.StringFlag{
+				Name:    "format", // Archive format of the new snapshot
+				Aliases: []string{"f"},
+				Usage:   "Archive format: zip, tar, tar.gz or tar.zst (default: from config, else zip)",
+			},
+			&cli.BoolFlag{
+				Name:  "retire", // Remove the old chain afterwards
+				Usage: "Remove the consolidated snapshots once the new one is verified",
+			},
+		},
+		Before:       requireFlags("destination"),
+		OnUsageError: onUsageError,
+		Action: func(c *cli.Context) error {
+			// Ensure at most one snapshot is given.
+			if c.NArg() > 1 {
+				return cli.Exit("expected at most one snapshot", exitUsage)
+			}
+			return backup.Consolidate(c.String("destination"), c.String("config"), c.String("identity"),
+				c.Args().First(), c.String("format"), c.Bool("retire"))
+		},
+	}
+}
+
+// configCommand defines the "config" command inspecting the configuration file.
+func configCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Check or show the configuration file",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "check",
+				Usage: "Validate the configuration file",
+				Action: func(c *cli.Context) error {
+					if err := backup.CheckConfig(c.String("config")); err != nil {
+						return err
+					}
+					fmt.Println("Configuration OK")
+					return nil
+				},
+			},
+			{
+				Name:  "show",
+				Usage: "Print the configuration as it is loaded",
+				Action: func(c *cli.Context) error {
+					// Load the configuration.
+					config, err := goback.LoadConfig(c.String("config"))
+					if err != nil {
+						return err
+					}
+
+					// Print it back as YAML, including defaulted settings.
+					data, err := yaml.Marshal(config)
+					if err != nil {
+						return fmt.Errorf("failed to marshal configuration: %w", err)
+					}
+					fmt.Print(string(data))
+					return nil
+				},
+			},
+		},
+	}
+}
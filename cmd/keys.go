@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ppriyankuu/goback/internals/storage"
+	"github.com/urfave/cli/v2"
+)
+
+// keyCommand defines the "key" command managing X25519 encryption keys.
+func keyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "key",
+		Usage: "Manage X25519 keys for recipient encryption",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "generate",
+				Usage: "Generate a new key pair and print its public key",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "output", // File receiving the private key
+						Aliases:  []string{"o"},
+						Usage:    "File to write the private key to",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					// Generate the key pair and write the private key.
+					recipient, err := storage.GenerateIdentity(c.String("output"))
+					if err != nil {
+						return err
+					}
+
+					// Print the public key to add to the recipients in config.yaml.
+					fmt.Println(recipient)
+					return nil
+				},
+			},
+			{
+				Name:      "inspect",
+				Usage:     "Print the public keys of a private key file",
+				ArgsUsage: "<file>",
+				Action: func(c *cli.Context) error {
+					// Ensure the key file is provided.
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected exactly one key file")
+					}
+
+					// Derive and print the public keys.
+					recipients, err := storage.InspectIdentity(c.Args().First())
+					if err != nil {
+						return err
+					}
+					for _, recipient := range recipients {
+						fmt.Println(recipient)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
-	"github.com/ppriyankuu/goback/internals/backup"
 	"github.com/urfave/cli/v2"
 )
 
+// Exit codes reported by goback.
+const (
+	exitFailure      = 1 // The operation failed.
+	exitUsage        = 2 // The command line is invalid.
+	exitVerifyFailed = 3 // At least one snapshot failed verification.
+)
+
 // main function initializes and runs the CLI application
 func main() {
 	// Define the CLI application
@@ -15,7 +22,7 @@ func main() {
 		Name:  "goback",                             // Application name
 		Usage: "A command-line backup utility tool", // Brief description
 
-		// Defile the CLI flags for configuration and operations.
+		// Define the flags shared by every command.
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "config", // Path to the config file
@@ -23,61 +30,63 @@ func main() {
 				Usage:   "Path to the configuration file",
 				Value:   "config.yaml", // Default config file
 			},
-			&cli.StringFlag{
-				Name:    "source", // Source directory to backup
-				Aliases: []string{"s"},
-				Usage:   "Source directory to back up",
-			},
-			&cli.StringFlag{
-				Name:    "destination", // Destination directory for backups
-				Aliases: []string{"d"},
-				Usage:   "Destination directory for backups",
-			},
-			&cli.BoolFlag{
-				Name:    "incremental", // Toggle for incremental backup
-				Aliases: []string{"i"},
-				Usage:   "Enable incremental backup",
-			},
-			&cli.BoolFlag{
-				Name:    "restore", // Toggle for restoration
-				Aliases: []string{"r"},
-				Usage:   "Restore from backup",
-			},
 		},
 
-		// Define the main action for the CLI
-		Action: func(c *cli.Context) error {
-			// Retrieve flag values.
-			configPath := c.String("config")
-			source := c.String("source")
-			destination := c.String("destination")
-			incremental := c.Bool("incremental")
-			restore := c.Bool("restore")
-
-			// Ensure essentials flags are provided.
-			if source == "" || destination == "" {
-				log.Fatalf("Source and destination directories are required")
-			}
-
-			// Perform restore or backup based on the flag.
-			if restore {
-				return backup.Restore(source, destination)
-			}
-
-			return backup.Backup(source, destination, incremental, configPath)
+		// Define the commands.
+		Commands: []*cli.Command{
+			backupCommand(),
+			restoreCommand(),
+			snapshotsCommand(),
+			lsCommand(),
+			findCommand(),
+			catCommand(),
+			verifyCommand(),
+			forgetCommand(),
+			pruneCommand(),
+			diffCommand(),
+			consolidateCommand(),
+			configCommand(),
+			keyCommand(),
 		},
 
-		// Hook to run before the main action.
-		Before: func(c *cli.Context) error {
-			if c.Bool("help") {
-				cli.ShowAppHelp(c) // Show help message if requested.
+		// Report invalid flags as usage errors.
+		OnUsageError: onUsageError,
+
+		// Show help without a command and reject unknown ones.
+		Action: func(c *cli.Context) error {
+			if c.NArg() > 0 {
+				return cli.Exit(fmt.Sprintf("unknown command %q", c.Args().First()), exitUsage)
 			}
-			return nil
+			return cli.ShowAppHelp(c)
 		},
 	}
 
 	// Run the application and handle errors.
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err) // Log fatal error if the app fails.
+		log.Println(err) // Log the error and exit if the app fails.
+		os.Exit(exitFailure)
+	}
+}
+
+// onUsageError turns a flag parsing error into an exit with the usage exit code.
+func onUsageError(c *cli.Context, err error, isSubcommand bool) error {
+	return cli.Exit(err.Error(), exitUsage)
+}
+
+// requireFlags returns a hook rejecting invocations that lack any of the named flags.
+
+// Parameters:
+// - names: The names of the required flags.
+
+// Returns:
+// - cli.BeforeFunc: The hook to run before the command's action.
+func requireFlags(names ...string) cli.BeforeFunc {
+	return func(c *cli.Context) error {
+		for _, name := range names {
+			if c.String(name) == "" {
+				return cli.Exit("missing required flag --"+name, exitUsage)
+			}
+		}
+		return nil
 	}
 }
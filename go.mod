@@ -1,14 +1,20 @@
 module github.com/ppriyankuu/goback
 
-go 1.24.0
+go 1.25.0
 
 require (
+	filippo.io/age v1.3.2
+	github.com/klauspost/compress v1.19.0
 	github.com/urfave/cli/v2 v2.27.6
+	golang.org/x/sys v0.47.0
+	golang.org/x/term v0.45.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
+	filippo.io/hpke v0.4.0 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
+	golang.org/x/crypto v0.55.0 // indirect
 )
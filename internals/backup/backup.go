@@ -2,31 +2,104 @@ package backup
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/fs"
 	"github.com/ppriyankuu/goback/internals/storage"
 )
 
-// Backup performs a full or incremental backup based on the provided flag.
+// Backup performs a full, incremental or differential backup. An incremental
+// backup builds on the most recent snapshot of the same source, a differential
+// one on its most recent full snapshot; both fall back to a full backup when
+// there is no such snapshot or a full one is due.
 
 // Parameters:
 // - source: The source directory or file to backup.
 // - destination: The destination directory where the backup will be stored.
-// - incremental: A boolean indicating whether to perform an incremental backup.
+// - snapshotType: The kind of backup to take: full, incremental or differential.
 // - configPath: The path to the config file.
+// - format: The archive format, overriding the configured one when not empty.
+// - mode: The storage mode, overriding the configured one when not empty.
+// - followSymlinks: Whether to back up the targets of symbolic links, in addition to the configured setting.
 
 // Returns:
 // - error: An error if performing the backup fails.
-func Backup(source, destination string, incremental bool, configPath string) error {
+func Backup(source, destination string, snapshotType storage.SnapshotType, configPath, format, mode string, followSymlinks bool) error {
 	// Load the configuration from the specified file.
 	config, err := cli.LoadConfig(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Create a backup archive, either full or incremental based on the flag.
-	archivePath, err := storage.CreateArchive(destination, source, incremental)
+	// Resolve the archive format, preferring the explicit one over the configuration.
+	if format == "" {
+		format = config.Format
+	}
+	archiveFormat, err := storage.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the storage mode the same way.
+	if mode == "" {
+		mode = config.Mode
+	}
+	storageMode, err := storage.ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	// Follow symbolic links when asked to here or in the configuration.
+	followSymlinks = followSymlinks || config.FollowSymlinks
+	xattrs := xattrFilter(config)
+
+	// Entries below .goback/ are reserved for goback's own data and never
+	// restored, so refuse to take a backup that could not be restored whole.
+	if err := checkReserved(source, followSymlinks); err != nil {
+		return err
+	}
+
+	// Prepare archive encryption from the configuration.
+	encryption := newEncryption(config)
+	if encryption.Enabled && storageMode == storage.ModeRepository {
+		return fmt.Errorf("encryption is not supported in repository mode")
+	}
+
+	// Note when the backup started; it becomes the snapshot's point in time.
+	start := time.Now()
+
+	// Find the chain an incremental or differential backup builds on, if any.
+	var chain []storage.Metadata
+	if snapshotType != storage.SnapshotFull {
+		if chain, err = parentChain(source, destination, snapshotType, storageMode, config, encryption); err != nil {
+			return err
+		}
+	}
+
+	var archivePath string
+	var written, state *storage.Manifest
+	parentID, baseID := "", ""
+	switch {
+	case storageMode == storage.ModeRepository:
+		// Store a deduplicated snapshot; unchanged data is never written twice.
+		archivePath, written, err = storage.CreateSnapshot(destination, source, followSymlinks, xattrs)
+		state = written
+	case len(chain) > 0:
+		// Archive only what changed since the parent snapshot.
+		parent := chain[len(chain)-1]
+		archivePath, written, state, err = createChanges(source, destination, snapshotType, parent, archiveFormat, encryption, config.CompareHashes, followSymlinks, xattrs)
+		parentID, baseID = parent.ID, chain[0].ID
+	default:
+		// Create a full backup archive.
+		archivePath, written, err = storage.CreateArchive(destination, source, followSymlinks, xattrs, archiveFormat, encryption)
+		state = written
+	}
+	if len(chain) == 0 {
+		snapshotType = storage.SnapshotFull
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create backup archive: %w", err)
 	}
@@ -34,28 +107,193 @@ func Backup(source, destination string, incremental bool, configPath string) err
 	// Track and log the progress of the backup operation
 	cli.TrackProgress("Backup created at: %s", archivePath)
 
+	// Record the snapshot in the catalog.
+	metadata, err := recordSnapshot(source, destination, archivePath, snapshotType, parentID, baseID, start, written, state, encryption)
+	if err != nil {
+		return err
+	}
+
+	// Verify the integrity of the backup archive. Archives encrypted to
+	// recipients can only be verified where a private key is available.
+	if encryption.UsesRecipients() && len(encryption.IdentityFiles) == 0 {
+		cli.TrackProgress("Skipping verification: archive is encrypted to recipients and no identity file is configured")
+	} else if err := storage.VerifySnapshot(metadata, encryption); err != nil {
+		return fmt.Errorf("failed to verify backup: %w", err)
+	}
+
+	// Clean up old backups based on the retention policy (time period)
+	if err := storage.CleanupOldBackups(destination, config.RetentionDays); err != nil {
+		return fmt.Errorf("failed to clean up old backups: %w", err)
+	}
+
+	return nil
+}
+
+// checkReserved refuses a source whose top level holds a directory named like
+// the one goback reserves inside archives for the embedded manifest.
+
+// Parameters:
+// - source: The source directory to back up.
+// - followSymlinks: Whether symbolic links are backed up as their target.
+
+// Returns:
+// - error: An error if the source holds such a directory.
+func checkReserved(source string, followSymlinks bool) error {
+	// A followed link to a directory is backed up as a directory too.
+	stat := os.Lstat
+	if followSymlinks {
+		stat = os.Stat
+	}
+	info, err := stat(filepath.Join(source, storage.ReservedDir))
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	return fmt.Errorf("%s holds a %s directory, whose entries goback reserves for its own data and could not restore; rename it or back up its contents separately",
+		source, storage.ReservedDir)
+}
+
+// newEncryption builds the storage encryption settings from the configuration.
+
+// Parameters:
+// - config: The loaded configuration.
+
+// Returns:
+// - *storage.Encryption: The encryption settings, resolving the passphrase lazily.
+func newEncryption(config *cli.Config) *storage.Encryption {
+	encryption := &storage.Encryption{
+		Enabled:    config.Encryption.Enabled,
+		Passphrase: cli.PassphraseSource(config.Encryption),
+		Recipients: config.Encryption.Recipients,
+	}
+	if config.Encryption.IdentityFile != "" {
+		encryption.IdentityFiles = []string{config.Encryption.IdentityFile}
+	}
+
+	return encryption
+}
+
+// xattrFilter builds the filter selecting the extended attributes to back up
+// from the configuration.
+
+// Parameters:
+// - config: The loaded configuration.
+
+// Returns:
+// - *fs.XattrFilter: The filter, capturing every attribute unless configured otherwise.
+func xattrFilter(config *cli.Config) *fs.XattrFilter {
+	return &fs.XattrFilter{
+		Include: config.Xattrs.Include,
+		Exclude: config.Xattrs.Exclude,
+	}
+}
+
+// loadEncryption loads the configuration and the encryption settings used to
+// read existing snapshots.
+
+// Parameters:
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+
+// Returns:
+// - *cli.Config: The loaded configuration.
+// - *storage.Encryption: The encryption settings.
+// - error: An error if the configuration cannot be loaded.
+func loadEncryption(configPath, identityFile string) (*cli.Config, *storage.Encryption, error) {
+	// Load the configuration from the specified file.
+	config, err := cli.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Prefer the explicit identity file over the configured one.
+	if identityFile != "" {
+		config.Encryption.IdentityFile = identityFile
+	}
+
+	return config, newEncryption(config), nil
+}
+
+// recordSnapshot creates the catalog record of a freshly written backup archive
+// and stores it together with the snapshot's manifest.
+
+// Parameters:
+// - source: The source directory that was backed up.
+// - destination: The destination directory holding the archive.
+// - archivePath: The path of the written archive.
+// - snapshotType: Whether the snapshot is full, incremental or differential.
+// - parent: The ID of the snapshot an incremental or differential snapshot builds on.
+// - base: The ID of the full snapshot at the start of its chain.
+// - start: The time the backup started.
+// - written: The manifest of the entries written to the archive.
+// - state: The manifest of the whole source tree at the time of the snapshot.
+// - encryption: The encryption settings the manifest is stored with.
+
+// Returns:
+// - storage.Metadata: The stored record.
+// - error: An error if the record cannot be created or stored.
+func recordSnapshot(source, destination, archivePath string, snapshotType storage.SnapshotType, parent, base string, start time.Time, written, state *storage.Manifest, encryption *storage.Encryption) (storage.Metadata, error) {
+	// Record the absolute source path and the host it was taken on.
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+	host, _ := os.Hostname()
+
 	// Create metadata for the backup
 	metadata := storage.Metadata{
+		Type:        snapshotType,
+		Parent:      parent,
+		Base:        base,
 		Source:      source,
 		Destination: destination,
+		Host:        host,
 		Path:        archivePath,
-		Time:        time.Now(),
+		StartTime:   start,
+		EndTime:     time.Now(),
 	}
 
-	// Store the metadata for future reference.
-	if err := storage.StoreMetadata(metadata); err != nil {
-		return fmt.Errorf("failed to store metadata: %w", err)
+	return storeSnapshot(metadata, written, state, encryption)
+}
+
+// storeSnapshot completes the catalog record of a freshly written archive and
+// stores it together with the snapshot's manifest.
+
+// Parameters:
+// - metadata: The record, describing the snapshot's origin, type and archive path.
+// - written: The manifest of the entries written to the archive.
+// - state: The manifest of the whole source tree at the time of the snapshot.
+// - encryption: The encryption settings the manifest is stored with.
+
+// Returns:
+// - storage.Metadata: The stored record.
+// - error: An error if the record cannot be created or stored.
+func storeSnapshot(metadata storage.Metadata, written, state *storage.Manifest, encryption *storage.Encryption) (storage.Metadata, error) {
+	// Generate the snapshot ID.
+	id, err := storage.NewSnapshotID()
+	if err != nil {
+		return storage.Metadata{}, err
 	}
+	metadata.ID = id
 
-	// Verify the integrity of the backup archive.
-	if err := storage.VerifyBackup(archivePath); err != nil {
-		return fmt.Errorf("failed to verify backup: %w", err)
+	// Checksum the archive so later modification can be detected.
+	if metadata.Checksum, err = storage.FileChecksum(metadata.Path); err != nil {
+		return storage.Metadata{}, fmt.Errorf("failed to checksum archive: %w", err)
 	}
 
-	// Clean up old backups based on the retention policy (time period)
-	if err := storage.CleanupOldBackups(destination, config.RetentionDays); err != nil {
-		return fmt.Errorf("failed to clean up old backups: %w", err)
+	// Summarise what the archive holds.
+	metadata.FileCount = written.FileCount()
+	metadata.Bytes = written.Bytes()
+
+	// Store the manifest of the whole tree first, so every catalog record has
+	// one and later incremental and differential backups can compare against it.
+	if err := storage.StoreManifest(metadata.Destination, id, state, encryption); err != nil {
+		return storage.Metadata{}, fmt.Errorf("failed to store manifest: %w", err)
 	}
 
-	return nil
+	// Store the metadata for future reference.
+	if err := storage.StoreMetadata(metadata); err != nil {
+		return storage.Metadata{}, fmt.Errorf("failed to store metadata: %w", err)
+	}
+
+	return metadata, nil
 }
@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+// SnapshotEntries lists the entries of a snapshot at and below a path, read
+// from its manifest without touching the archives.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - snapshotID: The ID or ID prefix of the snapshot, or "latest"; empty for the most recent.
+// - dir: The path to list, relative to the snapshot's source or absolute below it; empty for all.
+
+// Returns:
+// - storage.Metadata: The snapshot.
+// - []storage.Entry: The entries, in manifest order.
+// - error: An error if the snapshot cannot be read or holds nothing at the path.
+func SnapshotEntries(destination, configPath, identityFile, snapshotID, dir string) (storage.Metadata, []storage.Entry, error) {
+	// Read the manifest of the snapshot.
+	snapshot, manifest, err := SnapshotManifest(destination, configPath, identityFile, snapshotID)
+	if err != nil {
+		return storage.Metadata{}, nil, err
+	}
+
+	// Resolve the path against the snapshot's source.
+	prefix, err := entryName(dir, snapshot.Source)
+	if err != nil {
+		return storage.Metadata{}, nil, err
+	}
+	if prefix == "" {
+		return snapshot, manifest.Entries, nil
+	}
+
+	// Keep the entry itself and everything below it.
+	var entries []storage.Entry
+	for _, entry := range manifest.Entries {
+		if entry.Name == prefix || strings.HasPrefix(entry.Name, prefix+"/") {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return storage.Metadata{}, nil, fmt.Errorf("snapshot %s holds nothing at %s", snapshot.ID, prefix)
+	}
+
+	return snapshot, entries, nil
+}
+
+// FindMatch is an entry name found by Find, with the snapshots holding it.
+type FindMatch struct {
+	// Name is the slash-separated entry name.
+	Name string
+	// Snapshots lists the snapshots holding an entry of that name, oldest first.
+	Snapshots []storage.Metadata
+}
+
+// Find searches the manifests of every snapshot for entries matching a glob
+// pattern, using the same syntax as the include patterns of restores.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - pattern: The glob pattern, e.g. "*.conf" or "etc/**/hosts".
+
+// Returns:
+// - []FindMatch: The matching names, sorted, each with the snapshots holding it.
+// - error: An error if the pattern is malformed or a manifest cannot be read.
+func Find(destination, configPath, identityFile, pattern string) ([]FindMatch, error) {
+	// Load the encryption settings, needed for encrypted manifests.
+	_, encryption, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the pattern.
+	filter, err := fs.NewPathFilter(nil, []string{pattern}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the catalog.
+	snapshots, err := ListSnapshots(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	// Search the manifest of every snapshot.
+	found := make(map[string]*FindMatch)
+	for _, snapshot := range snapshots {
+		manifest, err := storage.ReadManifest(destination, snapshot.ID, encryption)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+		}
+		for _, entry := range manifest.Entries {
+			if !filter.Match(entry.Name) {
+				continue
+			}
+			match, ok := found[entry.Name]
+			if !ok {
+				match = &FindMatch{Name: entry.Name}
+				found[entry.Name] = match
+			}
+			match.Snapshots = append(match.Snapshots, snapshot)
+		}
+	}
+
+	// Sort the matches by name.
+	matches := make([]FindMatch, 0, len(found))
+	for _, match := range found {
+		matches = append(matches, *match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Name < matches[j].Name
+	})
+
+	return matches, nil
+}
+
+// Cat writes the contents of one file of a snapshot to a writer once they
+// have been checked against the snapshot's manifest, so nothing is written
+// if they are damaged. The file is read from the newest archive of the
+// snapshot's chain that holds it, without extracting anything.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - snapshotID: The ID or ID prefix of the snapshot, or "latest"; empty for the most recent.
+// - name: The path of the file, relative to the snapshot's source or absolute below it.
+// - w: The writer receiving the contents.
+
+// Returns:
+// - error: An error if the file is missing, not a regular file, damaged or cannot be read.
+func Cat(destination, configPath, identityFile, snapshotID, name string, w io.Writer) error {
+	// Load the encryption settings, needed for encrypted archives.
+	_, encryption, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return err
+	}
+
+	// Read the manifest of the snapshot.
+	snapshot, err := selectSnapshot(destination, snapshotID, "", "")
+	if err != nil {
+		return err
+	}
+	manifest, err := storage.ReadManifest(destination, snapshot.ID, encryption)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+	}
+
+	// Look the file up in it.
+	if name, err = entryName(name, snapshot.Source); err != nil {
+		return err
+	}
+	entry, ok := findEntry(manifest.Entries, name)
+	if !ok {
+		return fmt.Errorf("snapshot %s holds no %s", snapshot.ID, name)
+	}
+
+	// Hard links are stored under the first name of their file.
+	if entry.Type == fs.TypeHardlink {
+		target := entry.Linkname
+		if entry, ok = findEntry(manifest.Entries, target); !ok {
+			return fmt.Errorf("snapshot %s holds no %s", snapshot.ID, target)
+		}
+	}
+	if entry.Type != fs.TypeFile {
+		return fmt.Errorf("%s is a %s, not a regular file", name, entry.Type)
+	}
+
+	// Resolve the chain the file may have been written by.
+	chain, err := storage.SnapshotChain(destination, snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve snapshot chain: %w", err)
+	}
+
+	// The newest archive holding the file holds its version of the snapshot.
+	for i := len(chain) - 1; i >= 0; i-- {
+		found, err := copyVerified(chain[i].Path, entry, w, encryption)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot %s: %w", chain[i].ID, err)
+		}
+		if found {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is missing from the archives of snapshot %s", entry.Name, snapshot.ID)
+}
+
+// catMemoryLimit is the size up to which Cat holds a file in memory while
+// checking it; larger files are read twice instead.
+var catMemoryLimit int64 = 64 << 20
+
+// copyVerified writes the contents of a file stored in an archive to a writer
+// only after checking them against the file's manifest hash, so damaged or
+// tampered contents never reach the writer. Nothing is spooled to disk: files
+// up to catMemoryLimit are held in memory meanwhile, larger ones are read once
+// to check them and once more to write them.
+
+// Parameters:
+// - archivePath: The path to the archive file.
+// - entry: The manifest entry of the file.
+// - w: The writer receiving the contents.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - bool: True if the archive holds the file.
+// - error: An error if the archive cannot be read or the contents do not match the manifest.
+func copyVerified(archivePath string, entry storage.Entry, w io.Writer, encryption *storage.Encryption) (bool, error) {
+	// Without a recorded hash there is nothing to check.
+	if entry.Hash == "" {
+		return storage.CopyEntry(archivePath, entry.Name, w, encryption)
+	}
+	mismatch := fmt.Errorf("%s does not match its manifest", entry.Name)
+
+	// Check small files in memory, then write them.
+	if entry.Size <= catMemoryLimit {
+		var buffer bytes.Buffer
+		found, err := storage.CopyEntry(archivePath, entry.Name, &buffer, encryption)
+		if !found || err != nil {
+			return found, err
+		}
+		if sum := sha256.Sum256(buffer.Bytes()); hex.EncodeToString(sum[:]) != entry.Hash {
+			return true, mismatch
+		}
+		_, err = buffer.WriteTo(w)
+		return true, err
+	}
+
+	// Read larger files once to check them.
+	hash := sha256.New()
+	found, err := storage.CopyEntry(archivePath, entry.Name, hash, encryption)
+	if !found || err != nil {
+		return found, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != entry.Hash {
+		return true, mismatch
+	}
+
+	// Then once more to write them, checking again in case the archive changed in between.
+	hash.Reset()
+	if _, err := storage.CopyEntry(archivePath, entry.Name, io.MultiWriter(w, hash), encryption); err != nil {
+		return true, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != entry.Hash {
+		return true, fmt.Errorf("%s changed while it was being read", entry.Name)
+	}
+
+	return true, nil
+}
+
+// findEntry looks an entry up by name.
+func findEntry(entries []storage.Entry, name string) (storage.Entry, bool) {
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return storage.Entry{}, false
+}
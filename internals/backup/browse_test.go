@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+func TestCatWritesNothingForCorruptedEntries(t *testing.T) {
+	for _, limit := range []int64{catMemoryLimit, 0} {
+		source, destination, configPath := newTestStore(t, "format: tar\n")
+		writeFile(t, source, "file.txt", "ORIGINAL CONTENTS")
+		if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+			t.Fatal(err)
+		}
+
+		// The file reads back intact first.
+		saved := catMemoryLimit
+		catMemoryLimit = limit
+		t.Cleanup(func() { catMemoryLimit = saved })
+		var out bytes.Buffer
+		if err := Cat(destination, configPath, "", "latest", "file.txt", &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "ORIGINAL CONTENTS" {
+			t.Fatalf("limit %d: cat = %q", limit, out.String())
+		}
+
+		// Corrupt its contents inside the uncompressed tar archive.
+		archives, err := filepath.Glob(filepath.Join(destination, "*.tar"))
+		if err != nil || len(archives) != 1 {
+			t.Fatalf("archives = %v, %v", archives, err)
+		}
+		data, err := os.ReadFile(archives[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupted := bytes.Replace(data, []byte("ORIGINAL CONTENTS"), []byte("TAMPERED CONTENTS"), 1)
+		if bytes.Equal(corrupted, data) {
+			t.Fatal("contents not found in the archive")
+		}
+		if err := os.WriteFile(archives[0], corrupted, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		out.Reset()
+		if err := Cat(destination, configPath, "", "latest", "file.txt", &out); err == nil {
+			t.Errorf("limit %d: cat of a corrupted entry succeeded", limit)
+		}
+		if out.Len() != 0 {
+			t.Errorf("limit %d: cat wrote %q before detecting the corruption", limit, out.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+// Consolidate builds a synthetic full snapshot from a snapshot and the chain it
+// builds on, entirely from the destination. The new snapshot records the same
+// source and point in time, so restores no longer need the chain. When the
+// chain is to be retired, that is checked before the new snapshot is written.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - snapshotID: The ID or ID prefix of the snapshot to consolidate; empty for the most recent.
+// - format: The archive format of the new snapshot, overriding the configured one when not empty.
+// - retire: Whether to remove the consolidated chain afterwards.
+
+// Returns:
+// - error: An error if the chain cannot be merged, verified or retired.
+func Consolidate(destination, configPath, identityFile, snapshotID, format string, retire bool) error {
+	// Load the configuration and encryption settings.
+	config, encryption, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the archive format, preferring the explicit one over the configuration.
+	if format == "" {
+		format = config.Format
+	}
+	archiveFormat, err := storage.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	// Pick the snapshot to consolidate.
+	snapshot, err := selectSnapshot(destination, snapshotID, "", "")
+	if err != nil {
+		return err
+	}
+	if snapshot.Type == storage.SnapshotFull {
+		return fmt.Errorf("snapshot %s is already a full snapshot", snapshot.ID)
+	}
+
+	// Resolve its chain and the state of the tree it describes.
+	chain, err := storage.SnapshotChain(destination, snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve snapshot chain: %w", err)
+	}
+	state, err := storage.ReadManifest(destination, snapshot.ID, encryption)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+	}
+
+	// Make sure the chain can be retired before anything is written.
+	var retired []storage.Metadata
+	if retire {
+		ids := make([]string, len(chain))
+		for i, old := range chain {
+			ids[i] = old.ID
+		}
+		if retired, err = planForget(destination, ids); err != nil {
+			return fmt.Errorf("cannot retire the chain of snapshot %s: %w", snapshot.ID, err)
+		}
+	}
+
+	// Merge the chain into a new full archive.
+	archivePath, written, err := storage.ConsolidateChain(destination, chain, state, archiveFormat, encryption)
+	if err != nil {
+		return fmt.Errorf("failed to consolidate snapshot %s: %w", snapshot.ID, err)
+	}
+
+	// Record it as a full snapshot of the same source and point in time.
+	metadata, err := storeSnapshot(storage.Metadata{
+		Type:        storage.SnapshotFull,
+		Source:      snapshot.Source,
+		Destination: destination,
+		Host:        snapshot.Host,
+		Path:        archivePath,
+		StartTime:   snapshot.StartTime,
+		EndTime:     snapshot.EndTime,
+	}, written, state, encryption)
+	if err != nil {
+		return err
+	}
+	cli.TrackProgress("Consolidated %d snapshots into full snapshot %s at: %s", len(chain), metadata.ID, archivePath)
+
+	// Verify the new archive before anything is retired.
+	if err := storage.VerifySnapshot(metadata, encryption); err != nil {
+		return fmt.Errorf("failed to verify consolidated snapshot: %w", err)
+	}
+
+	// Optionally remove the chain the new snapshot replaces.
+	if retire {
+		if err := removeSnapshots(destination, retired); err != nil {
+			return fmt.Errorf("consolidated snapshot %s was created, but retiring the old chain stopped; finish with forget: %w", metadata.ID, err)
+		}
+	}
+
+	return nil
+}
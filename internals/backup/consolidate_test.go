@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+func TestConsolidateChecksRetireFirst(t *testing.T) {
+	source, destination, configPath := newTestStore(t, "format: tar\n")
+
+	// A full snapshot, an incremental to consolidate and one more built on it.
+	// Each is in its own format, so that they are named apart within the same second.
+	writeFile(t, source, "file.txt", "ONE")
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, source, "file.txt", "TWO")
+	if err := Backup(source, destination, storage.SnapshotIncremental, configPath, "zip", "", false); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := ListSnapshots(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middle := snapshots[1].ID
+	writeFile(t, source, "file.txt", "THREE")
+	if err := Backup(source, destination, storage.SnapshotIncremental, configPath, "tar.gz", "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retiring the middle chain would orphan the last snapshot: nothing may be written.
+	if err := Consolidate(destination, configPath, "", middle, "tar.zst", true); err == nil {
+		t.Fatal("consolidate --retire of a chain still built on succeeded")
+	}
+	after, err := ListSnapshots(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 3 {
+		t.Fatalf("got %d snapshots, want the 3 taken", len(after))
+	}
+	if archives, _ := filepath.Glob(filepath.Join(destination, "*.tar.zst")); len(archives) != 0 {
+		t.Errorf("consolidated archives %v were left behind", archives)
+	}
+
+	// The last snapshot's chain can be retired, leaving one full snapshot.
+	last := after[2].ID
+	if err := Consolidate(destination, configPath, "", last, "tar.zst", true); err != nil {
+		t.Fatal(err)
+	}
+	after, err = ListSnapshots(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || after[0].Type != storage.SnapshotFull {
+		t.Fatalf("snapshots = %+v, want a single full snapshot", after)
+	}
+	target := filepath.Join(t.TempDir(), "restore")
+	if err := Restore(destination, configPath, "", "", "", RestoreOptions{Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, target, "file.txt"); got != "THREE" {
+		t.Errorf("file.txt = %q, want %q", got, "THREE")
+	}
+}
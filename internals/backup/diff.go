@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// Diff compares a snapshot with a later snapshot or, when no later snapshot is
+// given, with the current state of the source directory.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - from: The ID or ID prefix of the earlier snapshot.
+// - to: The ID or ID prefix of the later snapshot; empty compares with source.
+// - source: The directory compared against when to is empty.
+
+// Returns:
+// - *fs.Changes: The added, modified, unchanged and deleted entries.
+// - error: An error if a snapshot cannot be read or the source cannot be scanned.
+func Diff(destination, configPath, identityFile, from, to, source string) (*fs.Changes, error) {
+	// Read the manifest of the earlier snapshot.
+	_, previous, err := SnapshotManifest(destination, configPath, identityFile, from)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare two snapshots by their manifests.
+	if to != "" {
+		_, current, err := SnapshotManifest(destination, configPath, identityFile, to)
+		if err != nil {
+			return nil, err
+		}
+		return fs.CompareEntries(previous.Entries, current.Entries), nil
+	}
+
+	// Otherwise compare the snapshot with the source as it is now.
+	if source == "" {
+		return nil, fmt.Errorf("a second snapshot or a source directory is required")
+	}
+	config, _, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := fs.DetectChanges(source, previous.Entries, config.CompareHashes, config.FollowSymlinks, xattrFilter(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect changes: %w", err)
+	}
+
+	return changes, nil
+}
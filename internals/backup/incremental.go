@@ -2,51 +2,179 @@ package backup
 
 import (
 	"fmt"
+	"path/filepath"
 	"time"
 
+	"github.com/ppriyankuu/goback/internals/cli"
 	"github.com/ppriyankuu/goback/internals/fs"
 	"github.com/ppriyankuu/goback/internals/storage"
 )
 
-// IncrementalBackup performs an incremental backup by detecting changes since the last backup.
+// parentChain resolves the chain an incremental or differential backup of the
+// source builds on. An incremental builds on the most recent snapshot of the
+// same source, a differential on its most recent full snapshot. When there is
+// no suitable snapshot, or the chain is due for a new full snapshot, the
+// backup falls back to a full one.
+
+// Parameters:
+// - source: The source directory being backed up.
+// - destination: The destination directory where backups are stored.
+// - snapshotType: Whether the backup is incremental or differential.
+// - mode: The storage mode of the backup.
+// - config: The loaded configuration, providing the full backup schedule.
+// - encryption: The encryption settings of the backup.
+
+// Returns:
+//   - []storage.Metadata: The base full snapshot up to the parent, oldest first,
+//     or nil to take a full backup.
+//   - error: An error if the catalog cannot be read.
+func parentChain(source, destination string, snapshotType storage.SnapshotType, mode storage.Mode, config *cli.Config, encryption *storage.Encryption) ([]storage.Metadata, error) {
+	// Repository snapshots only ever store new data, so they are always full.
+	if mode == storage.ModeRepository {
+		return nil, nil
+	}
+
+	// Manifests encrypted to recipients can only be read with a private key.
+	if encryption.UsesRecipients() && len(encryption.IdentityFiles) == 0 {
+		cli.TrackProgress("Taking a full backup: the previous manifest is encrypted to recipients and no identity file is configured")
+		return nil, nil
+	}
+
+	// Find the most recent suitable snapshot of the same source.
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+	snapshots, err := storage.ListMetadata(destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	var parent *storage.Metadata
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Source != source {
+			continue
+		}
+		if snapshotType == storage.SnapshotDifferential && snapshots[i].Type != storage.SnapshotFull {
+			continue
+		}
+		parent = &snapshots[i]
+		break
+	}
+	if parent == nil {
+		cli.TrackProgress("Taking a full backup: no previous snapshot of %s", source)
+		return nil, nil
+	}
+
+	// Repository snapshot trees cannot be the base of an archive.
+	if storage.IsSnapshotTree(parent.Path) {
+		cli.TrackProgress("Taking a full backup: the previous snapshot is stored in the repository")
+		return nil, nil
+	}
+
+	// Resolve the chain from the base full snapshot to the parent.
+	chain, err := storage.SnapshotChain(destination, *parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve snapshot chain: %w", err)
+	}
+
+	// Count the snapshots taken on top of the base full snapshot.
+	taken := len(chain) - 1
+	if snapshotType == storage.SnapshotDifferential {
+		taken = 0
+		for _, snapshot := range snapshots {
+			if snapshot.Type == storage.SnapshotDifferential && snapshot.Base == chain[0].ID {
+				taken++
+			}
+		}
+	}
+
+	// Start a new chain when the current one is long or old enough.
+	if config.FullEvery > 0 && taken >= config.FullEvery {
+		cli.TrackProgress("Taking a full backup: %d %s backups since the last full backup", taken, snapshotType)
+		return nil, nil
+	}
+	if config.FullEveryDays > 0 && time.Since(chain[0].StartTime) >= time.Duration(config.FullEveryDays)*24*time.Hour {
+		cli.TrackProgress("Taking a full backup: the last full backup is older than %d days", config.FullEveryDays)
+		return nil, nil
+	}
+
+	return chain, nil
+}
+
+// createChanges compares the source with the manifest of the parent snapshot
+// and archives only what changed.
 
 // Parameters:
 // - source: The source directory or file to back up.
 // - destination: The destination directory where the backup will be stored.
+// - snapshotType: Whether the backup is incremental or differential.
+// - parent: The snapshot the backup builds on.
+// - format: The archive format of the new archive.
+// - encryption: The encryption settings of the new archive.
+// - compareHashes: Whether to hash files whose metadata looks unchanged.
+// - followSymlinks: Whether to back up the targets of symbolic links.
+// - xattrs: The filter selecting the extended attributes to back up.
 
 // Returns:
+// - string: The path to the created archive.
+// - *storage.Manifest: The manifest of the entries written to the archive.
+// - *storage.Manifest: The manifest of the whole source tree.
 // - error: An error if any step in the process fails.
-func IncrementalBackup(source, destination string) error {
-	// Retrieve the most recent metadata for the specified destination.
-	metadata, err := storage.GetRecentMetadata(destination)
+func createChanges(source, destination string, snapshotType storage.SnapshotType, parent storage.Metadata, format storage.Format, encryption *storage.Encryption, compareHashes, followSymlinks bool, xattrs *fs.XattrFilter) (string, *storage.Manifest, *storage.Manifest, error) {
+	// Load the state of the source tree recorded by the parent snapshot.
+	previous, err := storage.ReadManifest(destination, parent.ID, encryption)
 	if err != nil {
-		return fmt.Errorf("failed to get recent metadata: %w", err)
+		return "", nil, nil, fmt.Errorf("failed to read manifest of snapshot %s: %w", parent.ID, err)
 	}
 
-	// Detect changes in the source directory since the last backup.
-	changes, err := fs.DetectChanges(source, metadata.Source)
+	// Detect changes in the source directory since the parent snapshot.
+	changes, err := fs.DetectChanges(source, previous.Entries, compareHashes, followSymlinks, xattrs)
 	if err != nil {
-		return fmt.Errorf("failed to detect changes: %w", err)
+		return "", nil, nil, fmt.Errorf("failed to detect changes: %w", err)
 	}
+	cli.TrackProgress("Detected %d added, %d modified, %d deleted and %d unchanged entries since snapshot %s",
+		len(changes.Added), len(changes.Modified), len(changes.Deleted), len(changes.Unchanged), parent.ID)
 
-	// Create an incremental archive containing only the detected changes.
-	archivePath, err := storage.CreateIncrementalArchive(destination, source, changes)
+	// Create an archive containing only the detected changes.
+	create := storage.CreateIncrementalArchive
+	if snapshotType == storage.SnapshotDifferential {
+		create = storage.CreateDifferentialArchive
+	}
+	archivePath, written, err := create(destination, source, changes.ChangedPaths(), changes.Deleted, followSymlinks, xattrs, format, encryption)
 	if err != nil {
-		return fmt.Errorf("failed to create incremental archive: %w", err)
+		return "", nil, nil, err
 	}
 
-	// Create new metadata for the incremental backup.
-	newMetadata := storage.Metadata{
-		Source:      source,
-		Destination: destination,
-		Path:        archivePath,
-		Time:        time.Now(),
+	return archivePath, written, stateManifest(changes, written), nil
+}
+
+// stateManifest describes the whole source tree after an incremental or differential backup:
+// the detected entries, with those written to the archive taking precedence
+// since they carry the hashes of the archived contents.
+
+// Parameters:
+// - changes: The changes detected against the previous snapshot.
+// - written: The manifest of the entries written to the archive.
+
+// Returns:
+// - *storage.Manifest: The manifest of the whole tree.
+func stateManifest(changes *fs.Changes, written *storage.Manifest) *storage.Manifest {
+	// Index the written entries by name.
+	archived := make(map[string]fs.FileEntry, len(written.Entries))
+	for _, entry := range written.Entries {
+		archived[entry.Name] = entry
 	}
 
-	// Store the new metadata for future reference
-	if err := storage.StoreMetadata(newMetadata); err != nil {
-		return fmt.Errorf("failed to store metadata: %w", err)
+	// Keep the traversal order of the detected entries.
+	state := &storage.Manifest{
+		Entries: make([]fs.FileEntry, 0, len(changes.Entries)),
+		Deleted: changes.Deleted,
+	}
+	for _, entry := range changes.Entries {
+		if record, ok := archived[entry.Name]; ok {
+			entry = record
+		}
+		state.Entries = append(state.Entries, entry)
 	}
 
-	return nil
+	return state
 }
@@ -0,0 +1,337 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/fs"
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+// ConflictPolicy decides what a restore does with entries that already exist
+// at the destination. Existing directories are always merged into.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces existing entries with the restored ones.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip keeps existing entries and restores only missing ones.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictNewer replaces existing entries only if the restored one was modified later.
+	ConflictNewer ConflictPolicy = "newer"
+	// ConflictRename moves existing entries aside before restoring.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictFail aborts the restore before anything is written.
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ParseConflictPolicy converts a user supplied policy name into a ConflictPolicy.
+
+// Parameters:
+// - name: The policy name: overwrite, skip, newer, rename or fail.
+
+// Returns:
+// - ConflictPolicy: The matching policy, or ConflictOverwrite when the name is empty.
+// - error: An error if the name is not a known policy.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(name)); policy {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictSkip, ConflictNewer, ConflictRename, ConflictFail:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown conflict policy %q, expected overwrite, skip, newer, rename or fail", name)
+}
+
+// restoreAction is what a restore does to one path of the destination.
+type restoreAction string
+
+const (
+	actionCreate    restoreAction = "create"
+	actionOverwrite restoreAction = "overwrite"
+	actionSkip      restoreAction = "skip"
+	actionRename    restoreAction = "rename"
+	actionDelete    restoreAction = "delete"
+	actionConflict  restoreAction = "conflict"
+)
+
+// plannedAction describes what a restore does to one path of the destination.
+type plannedAction struct {
+	action restoreAction
+	name   string
+	// path is the entry's path below the destination.
+	path string
+	// size is the size of the restored entry, or of the existing one when
+	// it is deleted or moved aside.
+	size int64
+	// replacesDir is set when a directory is overwritten by another type.
+	replacesDir bool
+}
+
+// restorePlan holds the decisions made before a restore writes anything.
+type restorePlan struct {
+	// names maps the archive name of every entry to write to the name it is
+	// restored under; nil writes every entry under its own name.
+	names map[string]string
+	// mapping renames entries as they are restored; nil keeps their names.
+	mapping *pathMapping
+	// partial is set for selective restores, which never remove anything.
+	partial bool
+	// kept lists the restored names of existing entries the chain's
+	// deletions must leave alone.
+	kept map[string]bool
+	// actions lists what happens to each affected path, in manifest order.
+	actions []plannedAction
+}
+
+// planRestore compares the entries a restore writes and the entries its
+// chain deletes with what already exists at the destination, and applies
+// the conflict policy.
+
+// Parameters:
+// - destination: The directory the snapshot is restored into.
+// - entries: The entries of the snapshot's manifest.
+// - deleted: The entries the snapshot's chain deletes that the snapshot does not hold.
+// - selected: The names of the entries to restore; nil restores every entry.
+// - mapping: The renaming applied to restored entries; nil keeps their names.
+// - policy: The conflict policy.
+
+// Returns:
+// - *restorePlan: The plan.
+// - error: An error if the destination cannot be inspected or an entry name is unsafe.
+func planRestore(destination string, entries []storage.Entry, deleted []string, selected map[string]bool, mapping *pathMapping, policy ConflictPolicy) (*restorePlan, error) {
+	plan := &restorePlan{mapping: mapping, partial: selected != nil}
+	names := make(map[string]string, len(entries))
+	skipped := make(map[string]bool)
+
+	for _, entry := range entries {
+		if selected != nil && !selected[entry.Name] {
+			continue
+		}
+
+		// Work out the name the entry is restored under, if any.
+		name, ok := mapping.apply(entry.Name)
+		if !ok {
+			continue
+		}
+		names[entry.Name] = name
+		if _, ok := names[entry.Linkname]; entry.Type == fs.TypeHardlink && !ok {
+			return nil, fmt.Errorf("hard link %s refers to %s, which is not restored", entry.Name, entry.Linkname)
+		}
+
+		// Entries below a skipped entry cannot be written either, and hard
+		// links cannot be written without the file they refer to.
+		if blocked(name, skipped) || (entry.Type == fs.TypeHardlink && skipped[names[entry.Linkname]]) {
+			skipped[name] = true
+			plan.actions = append(plan.actions, plannedAction{action: actionSkip, name: name, size: entry.Size})
+			continue
+		}
+
+		// Look at what exists at the entry's path.
+		dstPath, err := storage.SafePath(destination, name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(dstPath)
+		if os.IsNotExist(err) {
+			plan.actions = append(plan.actions, plannedAction{action: actionCreate, name: name, path: dstPath, size: entry.Size})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", dstPath, err)
+		}
+
+		// Existing directories are merged into rather than replaced.
+		if entry.Type == fs.TypeDir && info.IsDir() {
+			continue
+		}
+
+		// Apply the policy to everything else.
+		action := conflictAction(policy, entry, info)
+		if action == actionSkip {
+			skipped[name] = true
+		}
+		size := entry.Size
+		if action == actionRename {
+			size = info.Size()
+		}
+		plan.actions = append(plan.actions, plannedAction{action: action, name: name, path: dstPath, size: size, replacesDir: info.IsDir()})
+	}
+
+	removed := make(map[string]bool)
+	for _, name := range deleted {
+		// Deleted entries are looked for under the name they would be restored under.
+		name, ok := mapping.apply(name)
+		if !ok || blocked(name, removed) {
+			continue
+		}
+
+		// Deleting a path that does not exist is not worth reporting.
+		dstPath, err := storage.SafePath(destination, name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(dstPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", dstPath, err)
+		}
+
+		// Existing entries the chain deletes are conflicts too.
+		action := actionDelete
+		switch policy {
+		case ConflictSkip, ConflictNewer:
+			action = actionSkip
+		case ConflictRename:
+			action = actionRename
+		case ConflictFail:
+			action = actionConflict
+		}
+		plan.actions = append(plan.actions, plannedAction{action: action, name: name, path: dstPath, size: info.Size()})
+		removed[name] = true
+
+		// A kept path must not be written by the older archives of the chain
+		// that still hold it either.
+		if action == actionSkip {
+			skipped[name] = true
+		}
+	}
+
+	// Skipped entries are neither written nor deleted. Once anything is kept,
+	// every archive of the chain is limited to the entries of the snapshot,
+	// so none of them brings back a version of a kept path.
+	for archiveName, name := range names {
+		if skipped[name] || blocked(name, skipped) {
+			delete(names, archiveName)
+		}
+	}
+	if selected != nil || mapping != nil || len(skipped) > 0 {
+		plan.names = names
+	}
+	plan.kept = skipped
+
+	return plan, nil
+}
+
+// conflictAction decides what happens to an entry that exists at the destination.
+func conflictAction(policy ConflictPolicy, entry storage.Entry, existing os.FileInfo) restoreAction {
+	switch policy {
+	case ConflictSkip:
+		return actionSkip
+	case ConflictNewer:
+		if !entry.ModTime.After(existing.ModTime()) {
+			return actionSkip
+		}
+	case ConflictRename:
+		return actionRename
+	case ConflictFail:
+		return actionConflict
+	}
+
+	return actionOverwrite
+}
+
+// blocked reports whether any parent of an entry is in the set of names.
+func blocked(name string, names map[string]bool) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if names[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// conflicts returns the names of the entries the fail policy objects to.
+
+// Returns:
+// - []string: The conflicting names, in manifest order.
+func (p *restorePlan) conflicts() []string {
+	var names []string
+	for _, action := range p.actions {
+		if action.action == actionConflict {
+			names = append(names, action.name)
+		}
+	}
+	return names
+}
+
+// removable renames deleted entries like restored ones and filters them down
+// to those the plan does not keep.
+
+// Parameters:
+// - deleted: The names a snapshot recorded as deleted.
+
+// Returns:
+// - []string: The restored names to remove.
+func (p *restorePlan) removable(deleted []string) []string {
+	var names []string
+	for _, name := range deleted {
+		name, ok := p.mapping.apply(name)
+		if ok && !p.kept[name] && !blocked(name, p.kept) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// print reports every planned action and a summary of the plan.
+func (p *restorePlan) print() {
+	// One line per affected path.
+	counts := make(map[restoreAction]int)
+	sizes := make(map[restoreAction]int64)
+	for _, action := range p.actions {
+		cli.TrackProgress("%-9s %10s  %s", action.action, cli.FormatBytes(action.size), action.name)
+		counts[action.action]++
+		sizes[action.action] += action.size
+	}
+
+	// Then the totals of each kind of action.
+	var summary []string
+	for _, action := range []restoreAction{actionCreate, actionOverwrite, actionSkip, actionRename, actionDelete, actionConflict} {
+		if counts[action] > 0 {
+			summary = append(summary, fmt.Sprintf("%s %d (%s)", action, counts[action], cli.FormatBytes(sizes[action])))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "nothing to do")
+	}
+	cli.TrackProgress("Dry run: %s", strings.Join(summary, ", "))
+}
+
+// prepare moves existing entries aside and removes directories that are
+// replaced by another type of entry, before the snapshot is extracted.
+
+// Returns:
+// - error: An error if an existing entry cannot be moved or removed.
+func (p *restorePlan) prepare() error {
+	for _, action := range p.actions {
+		switch {
+		case action.action == actionRename:
+			// Move the existing entry to the first free name with a ".orig" suffix.
+			target := action.path + ".orig"
+			for i := 1; ; i++ {
+				if _, err := os.Lstat(target); os.IsNotExist(err) {
+					break
+				}
+				target = fmt.Sprintf("%s.orig.%d", action.path, i)
+			}
+			if err := os.Rename(action.path, target); err != nil {
+				return fmt.Errorf("failed to move %s aside: %w", action.name, err)
+			}
+			cli.TrackProgress("Moved existing %s to %s", action.name, target)
+		case action.action == actionOverwrite && action.replacesDir:
+			// A directory replaced by another type of entry goes with its contents.
+			if err := os.RemoveAll(action.path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", action.name, err)
+			}
+		}
+	}
+
+	return nil
+}
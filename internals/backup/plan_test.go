@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+func TestPlanRestoreSizes(t *testing.T) {
+	target := t.TempDir()
+	writeFile(t, target, "file.txt", "USER")
+	writeFile(t, target, "gone.txt", "OLD")
+
+	entries := []storage.Entry{{Name: "file.txt", Type: fs.TypeFile, Mode: 0644, Size: 5}}
+	tests := []struct {
+		policy ConflictPolicy
+		want   map[string]int64
+	}{
+		// Moved-aside and deleted entries count the existing size, the rest the restored one.
+		{ConflictRename, map[string]int64{"file.txt": 4, "gone.txt": 3}},
+		{ConflictOverwrite, map[string]int64{"file.txt": 5, "gone.txt": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			plan, err := planRestore(target, entries, []string{"gone.txt"}, nil, nil, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]int64)
+			for _, action := range plan.actions {
+				got[action.name] = action.size
+			}
+			for name, size := range tt.want {
+				if got[name] != size {
+					t.Errorf("%s: size = %d, want %d", name, got[name], size)
+				}
+			}
+		})
+	}
+}
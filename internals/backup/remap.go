@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pathMapping renames the entries of a snapshot as they are restored. Leading
+// components are stripped first, then the first mapping whose old prefix
+// matches replaces it with its new one.
+type pathMapping struct {
+	// strip is the number of leading components to remove.
+	strip int
+	// maps lists the old and new prefixes, in the order they were given.
+	maps [][2]string
+}
+
+// newPathMapping parses the strip count and "old=new" mappings of a restore.
+// Old prefixes may be given as absolute paths below the snapshot's source
+// directory, as well as relative to it like entry names.
+
+// Parameters:
+// - strip: The number of leading components to remove from every name.
+// - maps: The mappings, each "old=new"; an empty new prefix moves entries to the root.
+// - source: The source directory recorded for the snapshot.
+
+// Returns:
+// - *pathMapping: The mapping, or nil if it leaves every name unchanged.
+// - error: An error if the strip count is negative or a mapping is malformed.
+func newPathMapping(strip int, maps []string, source string) (*pathMapping, error) {
+	if strip < 0 {
+		return nil, fmt.Errorf("--strip-components must not be negative")
+	}
+	if strip == 0 && len(maps) == 0 {
+		return nil, nil
+	}
+
+	mapping := &pathMapping{strip: strip}
+	for _, m := range maps {
+		// Split the mapping at the first equals sign.
+		from, to, ok := strings.Cut(m, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping %q, expected old=new", m)
+		}
+
+		// Bring both sides into the form of entry names.
+		from, err := entryName(from, source)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %q: %w", m, err)
+		}
+		to = cleanName(to)
+		if from == "" {
+			return nil, fmt.Errorf("invalid mapping %q: the old prefix is empty", m)
+		}
+		mapping.maps = append(mapping.maps, [2]string{from, to})
+	}
+
+	return mapping, nil
+}
+
+// apply returns the name an entry is restored under.
+
+// Parameters:
+// - name: The slash-separated name recorded in the snapshot.
+
+// Returns:
+// - string: The name to restore the entry under.
+// - bool: False if the entry is not restored, because nothing is left of its name.
+func (m *pathMapping) apply(name string) (string, bool) {
+	// A nil mapping keeps every name.
+	if m == nil {
+		return name, true
+	}
+
+	// Strip the leading components.
+	parts := strings.Split(name, "/")
+	if len(parts) <= m.strip {
+		return "", false
+	}
+	name = strings.Join(parts[m.strip:], "/")
+
+	// Replace the first matching prefix.
+	for _, pair := range m.maps {
+		from, to := pair[0], pair[1]
+		if name == from {
+			return to, to != ""
+		}
+		if rest, ok := strings.CutPrefix(name, from+"/"); ok {
+			return path.Join(to, rest), true
+		}
+	}
+
+	return name, true
+}
+
+// entryName turns a user supplied path into the name of a snapshot entry.
+// Absolute paths are resolved against the source directory the snapshot was
+// taken of; other paths are taken to be relative to it already.
+
+// Parameters:
+// - name: The path given by the user.
+// - source: The source directory recorded for the snapshot.
+
+// Returns:
+// - string: The slash-separated entry name, "" for the root.
+// - error: An error if an absolute path lies outside the source directory.
+func entryName(name, source string) (string, error) {
+	if filepath.IsAbs(name) && source != "" {
+		rel, err := filepath.Rel(source, name)
+		if err != nil || !filepath.IsLocal(rel) {
+			return "", fmt.Errorf("%s is not below the snapshot source %s", name, source)
+		}
+		name = rel
+	}
+	return cleanName(name), nil
+}
+
+// cleanName turns a user supplied path into the form of an entry name:
+// slash-separated, without leading or trailing slashes, "" for the root.
+func cleanName(name string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
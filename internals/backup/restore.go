@@ -2,39 +2,457 @@ package backup
 
 import (
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/fs"
 	"github.com/ppriyankuu/goback/internals/storage"
 )
 
-// Restore restores data from the most recent backup to the specified destination.
+// RestoreOptions selects what part of a snapshot a restore writes, where it
+// writes it and how it treats what already exists there.
+type RestoreOptions struct {
+	// Target is the directory to restore into; it must lie apart from the backup destination.
+	Target string
+	// Source picks the latest or as-of snapshot among the backups of this
+	// directory; it is required when the destination holds several sources.
+	Source string
+	// StripComponents is the number of leading components removed from every entry name.
+	StripComponents int
+	// Map lists "old=new" prefixes renaming entries as they are restored.
+	Map []string
+	// Paths lists the entries to restore together with everything below them,
+	// relative to the snapshot's source or absolute below it; empty for all.
+	Paths []string
+	// Include lists glob patterns of entries to restore, e.g. "etc/nginx/**".
+	Include []string
+	// Exclude lists glob patterns of entries not to restore, e.g. "*.log".
+	Exclude []string
+	// OnConflict names the conflict policy: overwrite, skip, newer, rename or fail.
+	OnConflict string
+	// DryRun reports what the restore would do without writing anything.
+	DryRun bool
+}
+
+// Restore restores a snapshot from the specified destination into a target
+// directory: the given one, the newest one taken at or before a point in time,
+// or else the most recent. Unless a snapshot ID is given, the choice is made
+// among the snapshots of one source, so a destination holding several sources
+// needs the source named. The
+// snapshot is reconstructed by replaying its chain: the full snapshot first,
+// then each incremental or differential in order, removing the entries each one deleted.
+// Paths and patterns restore only part of the snapshot: they are resolved
+// against its manifest, and only the matching entries are read from the
+// archives. Paths may be absolute below the snapshot's source, and each must
+// match something.
+// Entries that already exist in the target are handled by the conflict
+// policy before anything is written. Entry names are relative to the source
+// directory the snapshot was taken of, so a snapshot can be restored anywhere,
+// optionally stripping or remapping leading parts of the names.
 
 // Parameters:
-// - source: The source directory or file to restore.
-// - destination: The destination directory where the backup will be restored.
+// - destination: The destination directory holding the backups.
+// - configPath: The path to the config file.
+// - identityFile: The private key file for archives encrypted to recipients, overriding the configured one when not empty.
+// - snapshotID: The ID, or a unique prefix of it, of the snapshot to restore; empty for none.
+// - asOf: The point in time to restore, e.g. "2026-10-01 12:00"; empty for none.
+// - options: The target, selection, renaming, conflict policy and dry-run setting.
 
 // Returns:
 // - error: An error if any step in the restore process fails.
-func Restore(source, destination string) error {
-	// Load the configuration from the specified YAML file.
-	_, err := cli.LoadConfig("config.yaml")
+func Restore(destination, configPath, identityFile, snapshotID, asOf string, options RestoreOptions) error {
+	// Load the configuration and encryption settings.
+	_, encryption, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return err
+	}
+
+	// Parse the conflict policy.
+	policy, err := ParseConflictPolicy(options.OnConflict)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the target lies apart from the backups.
+	target := options.Target
+	if err := checkTarget(destination, target); err != nil {
+		return err
+	}
+
+	// Pick the snapshot to restore.
+	snapshot, err := selectSnapshot(destination, snapshotID, asOf, options.Source)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the paths selecting what to restore against the snapshot's
+	// source, as ls and cat do, and parse the patterns.
+	paths := make([]string, len(options.Paths))
+	for i, p := range options.Paths {
+		if paths[i], err = entryName(p, snapshot.Source); err != nil {
+			return err
+		}
+	}
+	filter, err := fs.NewPathFilter(paths, options.Include, options.Exclude)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return err
 	}
 
-	// Retrieve the most recent backup for the specified destination.
-	recentBackup, err := storage.GetRecentBackup(destination)
+	// Resolve how to name the restored entries.
+	mapping, err := newPathMapping(options.StripComponents, options.Map, snapshot.Source)
 	if err != nil {
-		return fmt.Errorf("failed to get recent backup: %w", err)
+		return err
+	}
+
+	// Read the manifest listing everything the snapshot holds.
+	manifest, err := storage.ReadManifest(snapshot.Destination, snapshot.ID, encryption)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+	}
+
+	// Resolve the selection against it; every path given must be in it.
+	for _, p := range paths {
+		if !holdsPath(manifest.Entries, p) {
+			return fmt.Errorf("snapshot %s holds nothing at %s", snapshot.ID, p)
+		}
+	}
+	var selected map[string]bool
+	if filter.Selective() {
+		selected = selectEntries(manifest.Entries, filter)
+		if len(selected) == 0 {
+			return fmt.Errorf("no entries of snapshot %s match the given paths and patterns", snapshot.ID)
+		}
+	}
+
+	// Resolve the full snapshot and the snapshots leading up to it, together
+	// with the entries each of them deleted.
+	chain, err := storage.SnapshotChain(destination, snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve snapshot chain: %w", err)
+	}
+	deletions, err := chainDeletions(chain, encryption)
+	if err != nil {
+		return err
+	}
+
+	// Decide what happens to everything that already exists in the target.
+	var deleted []string
+	if selected == nil {
+		deleted = deletedEntries(deletions, manifest.Entries)
+	}
+	plan, err := planRestore(target, manifest.Entries, deleted, selected, mapping, policy)
+	if err != nil {
+		return err
+	}
+
+	// A dry run only reports the plan.
+	if options.DryRun {
+		plan.print()
+		return nil
+	}
+	if conflicts := plan.conflicts(); len(conflicts) > 0 {
+		return fmt.Errorf("restore would change %d existing entries, e.g. %s; choose what happens to them with --on-conflict",
+			len(conflicts), strings.Join(conflicts[:min(len(conflicts), 5)], ", "))
+	}
+
+	// Only root can give restored files their recorded owner and create device nodes.
+	if !fs.CanRestoreOwnership() {
+		cli.TrackProgress("Not running as root: restored files will be owned by the current user and device nodes are skipped")
 	}
 
-	// Extract the most recent backup archive to the destination directory.
-	if err := storage.ExtractArchive(recentBackup.Path, destination); err != nil {
-		return fmt.Errorf("failed to extract backup: %w", err)
+	// Create the target, move conflicting entries out of the way, then replay the chain onto it.
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+	if err := plan.prepare(); err != nil {
+		return err
+	}
+	if err := restoreChain(chain, deletions, target, plan, encryption); err != nil {
+		return err
 	}
 
 	// Track and log the progress of the restore operation.
-	cli.TrackProgress("Restored from: %s", recentBackup)
+	if selected != nil {
+		cli.TrackProgress("Restored %d selected entries", len(selected))
+	}
+	cli.TrackProgress("Restored snapshot %s (%s) from: %s into: %s", snapshot.ID, snapshot.StartTime.Format(time.DateTime), snapshot.Path, target)
 
 	return nil
 }
+
+// checkTarget makes sure a restore cannot write into the backup store: restored
+// entries and deletions landing next to the catalog, manifests and archives
+// could overwrite or remove them.
+
+// Parameters:
+// - destination: The directory holding the backups.
+// - target: The directory to restore into.
+
+// Returns:
+// - error: An error if the target is missing or equal to, inside or containing the destination.
+func checkTarget(destination, target string) error {
+	if target == "" {
+		return fmt.Errorf("no target directory given to restore into")
+	}
+
+	// Compare absolute paths, with symbolic links resolved as far as they exist.
+	dst, err := resolvePath(destination)
+	if err != nil {
+		return err
+	}
+	tgt, err := resolvePath(target)
+	if err != nil {
+		return err
+	}
+	if within(tgt, dst) || within(dst, tgt) {
+		return fmt.Errorf("target %s overlaps the backup destination %s; restore into a separate directory", target, destination)
+	}
+
+	return nil
+}
+
+// resolvePath makes a path absolute and resolves the symbolic links in the
+// longest part of it that exists.
+func resolvePath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+
+	// Resolve the existing part and append the rest unchanged.
+	rest := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if dir == filepath.Dir(dir) {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// within reports whether a path is equal to or below a directory.
+func within(name, dir string) bool {
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// selectSnapshot finds the snapshot a restore should reconstruct.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - snapshotID: The ID or ID prefix of the snapshot, or "latest"; empty for none.
+// - asOf: The point in time of the snapshot; empty for none.
+// - source: The source directory the snapshot must be of; empty for any source.
+
+// Returns:
+//   - storage.Metadata: The selected snapshot.
+//   - error: An error if both selectors are given, no snapshot matches, or
+//     the newest snapshots of several sources match and no source was given.
+func selectSnapshot(destination, snapshotID, asOf, source string) (storage.Metadata, error) {
+	// "latest" names the most recent snapshot explicitly.
+	if snapshotID == "latest" {
+		snapshotID = ""
+	}
+
+	// Compare the source the way backups record it.
+	if source != "" {
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return storage.Metadata{}, fmt.Errorf("failed to resolve source: %w", err)
+		}
+		source = abs
+	}
+
+	switch {
+	case snapshotID != "" && asOf != "":
+		return storage.Metadata{}, fmt.Errorf("a snapshot ID and a point in time cannot both be given")
+	case snapshotID != "":
+		// Look the snapshot up by its ID.
+		snapshot, err := storage.GetMetadata(destination, snapshotID)
+		if err != nil {
+			return storage.Metadata{}, fmt.Errorf("failed to find snapshot: %w", err)
+		}
+		if source != "" && snapshot.Source != source {
+			return storage.Metadata{}, fmt.Errorf("snapshot %s is of %s, not %s", snapshot.ID, snapshot.Source, source)
+		}
+		return snapshot, nil
+	case asOf != "":
+		// Take the newest snapshot not newer than the given time.
+		t, err := cli.ParseTime(asOf)
+		if err != nil {
+			return storage.Metadata{}, err
+		}
+		snapshot, err := storage.GetMetadataAsOf(destination, source, t)
+		if err != nil {
+			return storage.Metadata{}, fmt.Errorf("failed to find snapshot: %w", err)
+		}
+		return snapshot, nil
+	}
+
+	// Default to the most recent backup of the source.
+	snapshot, err := storage.GetRecentMetadata(destination, source)
+	if err != nil {
+		return storage.Metadata{}, fmt.Errorf("failed to get recent backup: %w", err)
+	}
+	return snapshot, nil
+}
+
+// chainDeletions reads the entries each snapshot of a chain recorded as deleted.
+
+// Parameters:
+// - chain: The snapshots of the chain, oldest first.
+// - encryption: The encryption settings used to decrypt manifests.
+
+// Returns:
+// - [][]string: The deleted names of each snapshot, in chain order; none for full snapshots.
+// - error: An error if a manifest cannot be read.
+func chainDeletions(chain []storage.Metadata, encryption *storage.Encryption) ([][]string, error) {
+	deletions := make([][]string, len(chain))
+	for i, snapshot := range chain {
+		if snapshot.Type == storage.SnapshotFull {
+			continue
+		}
+		manifest, err := storage.ReadManifest(snapshot.Destination, snapshot.ID, encryption)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+		}
+		deletions[i] = manifest.Deleted
+	}
+
+	return deletions, nil
+}
+
+// deletedEntries lists the entries a chain deletes that the restored snapshot
+// no longer holds, each name once.
+
+// Parameters:
+// - deletions: The deleted names of each snapshot of the chain.
+// - entries: The entries of the restored snapshot.
+
+// Returns:
+// - []string: The deleted names, in chain order.
+func deletedEntries(deletions [][]string, entries []storage.Entry) []string {
+	// Names the snapshot still holds are restored rather than deleted.
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		seen[entry.Name] = true
+	}
+
+	var names []string
+	for _, deleted := range deletions {
+		for _, name := range deleted {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// restoreChain extracts each snapshot of a chain in order, applying the
+// deletions recorded by incremental and differential snapshots. Directory
+// metadata is applied last, once every snapshot has been extracted.
+
+// Parameters:
+// - chain: The snapshots to apply, oldest first.
+// - deletions: The deleted names of each snapshot, in chain order.
+// - destination: The directory the snapshots are restored into.
+// - plan: The plan choosing the entries to write, their names and the existing ones to keep.
+// - encryption: The encryption settings used to decrypt archives.
+
+// Returns:
+// - error: An error if any snapshot cannot be applied.
+func restoreChain(chain []storage.Metadata, deletions [][]string, destination string, plan *restorePlan, encryption *storage.Encryption) error {
+	// The latest version of every extracted directory, by name.
+	dirs := make(map[string]storage.Entry)
+
+	for i, snapshot := range chain {
+		// Remove the entries the snapshot recorded as deleted first, so an
+		// entry replaced by one of a different type is out of the way. Partial
+		// restores only write entries that still exist, each replacing what is
+		// at its path, so they leave everything else alone.
+		if !plan.partial {
+			if err := storage.RemoveDeleted(destination, plan.removable(deletions[i])); err != nil {
+				return err
+			}
+		}
+
+		// Extract the snapshot's archive, decrypting it if necessary.
+		extracted, err := storage.ExtractArchive(snapshot.Path, destination, plan.names, encryption)
+		if err != nil {
+			return fmt.Errorf("failed to extract snapshot %s: %w", snapshot.ID, err)
+		}
+		for _, dir := range extracted {
+			dirs[dir.Name] = dir
+		}
+	}
+
+	// Apply the metadata of the directories now that their contents are in place.
+	restored := make([]storage.Entry, 0, len(dirs))
+	for _, dir := range dirs {
+		restored = append(restored, dir)
+	}
+	if err := storage.RestoreDirectories(destination, restored); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// selectEntries resolves a path filter against the entries of a manifest. The
+// parent directories of selected entries are selected too, so they get their
+// recorded metadata back, as are the files hard links of the selection refer to.
+
+// Parameters:
+// - entries: The entries of the snapshot's manifest.
+// - filter: The filter choosing the entries to restore.
+
+// Returns:
+// - map[string]bool: The names of the selected entries.
+func selectEntries(entries []storage.Entry, filter *fs.PathFilter) map[string]bool {
+	selected := make(map[string]bool)
+	for _, entry := range entries {
+		if !filter.Match(entry.Name) {
+			continue
+		}
+		selected[entry.Name] = true
+
+		// Hard links can only be restored next to the file they refer to.
+		if entry.Type == fs.TypeHardlink {
+			selected[entry.Linkname] = true
+		}
+	}
+
+	// Add the parent directories of everything selected.
+	for name := range selected {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			selected[dir] = true
+		}
+	}
+
+	return selected
+}
+
+// holdsPath reports whether a manifest holds an entry at or below a path.
+
+// Parameters:
+// - entries: The entries of the snapshot's manifest.
+// - name: The slash-separated entry name, "" for the root.
+
+// Returns:
+// - bool: True if the entry or anything below it exists.
+func holdsPath(entries []storage.Entry, name string) bool {
+	for _, entry := range entries {
+		if name == "" || entry.Name == name || strings.HasPrefix(entry.Name, name+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+// newTestStore creates a source directory, a backup destination and a config
+// file in a temporary directory.
+func newTestStore(t *testing.T, config string) (source, destination, configPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	source = filepath.Join(dir, "src")
+	destination = filepath.Join(dir, "dst")
+	configPath = filepath.Join(dir, "config.yaml")
+	for _, d := range []string{source, destination} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return source, destination, configPath
+}
+
+// writeFile writes a file below a directory, creating its parents.
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns the contents of a file below a directory.
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRestoreKeepsDeletedPathsItSkips(t *testing.T) {
+	for _, format := range []string{"zip", "tar"} {
+		for _, policy := range []string{"skip", "newer"} {
+			t.Run(format+"/"+policy, func(t *testing.T) {
+				source, destination, configPath := newTestStore(t, "format: "+format+"\n")
+
+				// Back up old.txt, then delete it so the incremental records a tombstone.
+				writeFile(t, source, "keep.txt", "KEEP")
+				writeFile(t, source, "old.txt", "BACKUP")
+				if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Remove(filepath.Join(source, "old.txt")); err != nil {
+					t.Fatal(err)
+				}
+				if err := Backup(source, destination, storage.SnapshotIncremental, configPath, "", "", false); err != nil {
+					t.Fatal(err)
+				}
+
+				// The target already holds its own old.txt.
+				target := filepath.Join(t.TempDir(), "restore")
+				writeFile(t, target, "old.txt", "USER")
+
+				if err := Restore(destination, configPath, "", "", "", RestoreOptions{Target: target, OnConflict: policy}); err != nil {
+					t.Fatal(err)
+				}
+				if got := readFile(t, target, "old.txt"); got != "USER" {
+					t.Errorf("old.txt = %q, want the existing %q kept", got, "USER")
+				}
+				if got := readFile(t, target, "keep.txt"); got != "KEEP" {
+					t.Errorf("keep.txt = %q, want %q", got, "KEEP")
+				}
+			})
+		}
+	}
+}
+
+func TestBackupRefusesReservedDirectory(t *testing.T) {
+	source, destination, configPath := newTestStore(t, "format: tar\n")
+	writeFile(t, source, "file.txt", "data")
+	writeFile(t, source, ".goback/notes.txt", "mine")
+
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err == nil {
+		t.Fatal("backup of a source holding .goback/ succeeded")
+	}
+	snapshots, err := ListSnapshots(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 0 {
+		t.Errorf("got %d snapshots, want none", len(snapshots))
+	}
+
+	// A plain file of that name is not reserved.
+	if err := os.RemoveAll(filepath.Join(source, ".goback")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, source, ".goback", "file")
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "restore")
+	if err := Restore(destination, configPath, "", "", "", RestoreOptions{Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, target, ".goback"); got != "file" {
+		t.Errorf(".goback = %q, want %q", got, "file")
+	}
+}
+
+func TestRestorePaths(t *testing.T) {
+	source, destination, configPath := newTestStore(t, "format: tar\n")
+	writeFile(t, source, "etc/hosts", "HOSTS")
+	writeFile(t, source, "var/log/app.log", "LOG")
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative and absolute paths select the same entries.
+	for _, p := range []string{"etc/hosts", filepath.Join(source, "etc", "hosts"), filepath.Join(source, "etc")} {
+		target := filepath.Join(t.TempDir(), "restore")
+		if err := Restore(destination, configPath, "", "", "", RestoreOptions{Target: target, Paths: []string{p}}); err != nil {
+			t.Fatalf("path %s: %v", p, err)
+		}
+		if got := readFile(t, target, "etc/hosts"); got != "HOSTS" {
+			t.Errorf("path %s: etc/hosts = %q, want %q", p, got, "HOSTS")
+		}
+		if _, err := os.Lstat(filepath.Join(target, "var")); err == nil {
+			t.Errorf("path %s: unselected var was restored", p)
+		}
+	}
+
+	// Paths matching nothing, or outside the source, are errors that write nothing.
+	for _, p := range []string{"etc/missing", filepath.Join(source, "missing"), "/elsewhere/etc/hosts"} {
+		target := filepath.Join(t.TempDir(), "restore")
+		if err := Restore(destination, configPath, "", "", "", RestoreOptions{Target: target, Paths: []string{"etc/hosts", p}}); err == nil {
+			t.Errorf("path %s: restore succeeded", p)
+		}
+		if _, err := os.Lstat(target); err == nil {
+			t.Errorf("path %s: target was created", p)
+		}
+	}
+}
+
+func TestRepositoryBackupsInTheSameSecond(t *testing.T) {
+	source, destination, configPath := newTestStore(t, "mode: repository\n")
+
+	// Take two backups back to back, well within the same second.
+	writeFile(t, source, "file.txt", "FIRST")
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, source, "file.txt", "SECOND")
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each snapshot has its own tree and restores its own contents.
+	snapshots, err := ListSnapshots(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Path == snapshots[1].Path {
+		t.Fatalf("snapshots = %+v, want two with their own trees", snapshots)
+	}
+	for i, want := range []string{"FIRST", "SECOND"} {
+		target := filepath.Join(t.TempDir(), "restore")
+		if err := Restore(destination, configPath, "", snapshots[i].ID, "", RestoreOptions{Target: target}); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, target, "file.txt"); got != want {
+			t.Errorf("snapshot %d: file.txt = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRestorePicksSnapshotOfSource(t *testing.T) {
+	source, destination, configPath := newTestStore(t, "format: tar\n")
+	other := filepath.Join(t.TempDir(), "other")
+
+	// Back up two sources into the same destination, the other one last and
+	// as zip so that its archive is named apart within the same second.
+	writeFile(t, source, "file.txt", "SOURCE")
+	writeFile(t, other, "file.txt", "OTHER")
+	if err := Backup(source, destination, storage.SnapshotFull, configPath, "", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Backup(other, destination, storage.SnapshotFull, configPath, "zip", "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a source, the latest and as-of snapshots are ambiguous.
+	for _, asOf := range []string{"", "2100-01-01"} {
+		target := filepath.Join(t.TempDir(), "restore")
+		if err := Restore(destination, configPath, "", "", asOf, RestoreOptions{Target: target}); err == nil {
+			t.Errorf("as of %q: restore without a source succeeded", asOf)
+		}
+	}
+
+	// With one, the snapshot of that source is restored.
+	for _, asOf := range []string{"", "2100-01-01"} {
+		target := filepath.Join(t.TempDir(), "restore")
+		if err := Restore(destination, configPath, "", "", asOf, RestoreOptions{Target: target, Source: source}); err != nil {
+			t.Fatalf("as of %q: %v", asOf, err)
+		}
+		if got := readFile(t, target, "file.txt"); got != "SOURCE" {
+			t.Errorf("as of %q: file.txt = %q, want %q", asOf, got, "SOURCE")
+		}
+	}
+}
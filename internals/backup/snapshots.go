@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+// ListSnapshots returns the snapshots recorded in the catalog of a destination.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+
+// Returns:
+// - []storage.Metadata: The snapshots, oldest first.
+// - error: An error if the catalog cannot be read.
+func ListSnapshots(destination string) ([]storage.Metadata, error) {
+	snapshots, err := storage.ListMetadata(destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	return snapshots, nil
+}
+
+// SnapshotManifest loads a snapshot and the manifest describing its whole tree.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - snapshotID: The ID or ID prefix of the snapshot; empty for the most recent.
+
+// Returns:
+// - storage.Metadata: The snapshot.
+// - *storage.Manifest: Its manifest.
+// - error: An error if the snapshot or its manifest cannot be read.
+func SnapshotManifest(destination, configPath, identityFile, snapshotID string) (storage.Metadata, *storage.Manifest, error) {
+	// Load the encryption settings, needed for encrypted manifests.
+	_, encryption, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return storage.Metadata{}, nil, err
+	}
+
+	// Find the snapshot.
+	snapshot, err := selectSnapshot(destination, snapshotID, "", "")
+	if err != nil {
+		return storage.Metadata{}, nil, err
+	}
+
+	// Read its manifest.
+	manifest, err := storage.ReadManifest(destination, snapshot.ID, encryption)
+	if err != nil {
+		return storage.Metadata{}, nil, fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+	}
+
+	return snapshot, manifest, nil
+}
+
+// Forget removes snapshots from a destination. Snapshots that later
+// incremental snapshots still build on are refused.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - snapshotIDs: The IDs or ID prefixes of the snapshots to remove.
+
+// Returns:
+// - error: An error if a snapshot is unknown, still needed or cannot be removed.
+func Forget(destination string, snapshotIDs []string) error {
+	// Resolve every snapshot before removing anything.
+	targets, err := planForget(destination, snapshotIDs)
+	if err != nil {
+		return err
+	}
+
+	// Remove the snapshots.
+	return removeSnapshots(destination, targets)
+}
+
+// planForget resolves the snapshots to forget and checks that removing them
+// leaves no kept snapshot without its parent.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - snapshotIDs: The IDs or ID prefixes of the snapshots to remove.
+
+// Returns:
+// - []storage.Metadata: The snapshots to remove, in the given order.
+// - error: An error if a snapshot is unknown or still needed.
+func planForget(destination string, snapshotIDs []string) ([]storage.Metadata, error) {
+	// Resolve every snapshot.
+	var targets []storage.Metadata
+	forget := make(map[string]bool)
+	for _, id := range snapshotIDs {
+		snapshot, err := storage.GetMetadata(destination, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find snapshot: %w", err)
+		}
+		targets = append(targets, snapshot)
+		forget[snapshot.ID] = true
+	}
+
+	// Refuse to break the chain of a snapshot that is kept.
+	snapshots, err := storage.ListMetadata(destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Parent != "" && forget[snapshot.Parent] && !forget[snapshot.ID] {
+			return nil, fmt.Errorf("snapshot %s is the parent of %s; forget both or neither", snapshot.Parent, snapshot.ID)
+		}
+	}
+
+	return targets, nil
+}
+
+// removeSnapshots removes planned snapshots and releases the repository data
+// only they referenced. If a removal fails, the error names the snapshots
+// still to remove, so that forget can finish the job.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - targets: The snapshots to remove, as resolved by planForget.
+
+// Returns:
+// - error: An error if a snapshot cannot be removed or the repository cannot be pruned.
+func removeSnapshots(destination string, targets []storage.Metadata) error {
+	// Remove the snapshots, reporting each one as it goes.
+	for i, snapshot := range targets {
+		if err := storage.RemoveSnapshot(snapshot); err != nil {
+			remaining := make([]string, 0, len(targets)-i)
+			for _, left := range targets[i:] {
+				remaining = append(remaining, left.ID)
+			}
+			return fmt.Errorf("failed to remove snapshot %s (still to forget: %s): %w", snapshot.ID, strings.Join(remaining, " "), err)
+		}
+		cli.TrackProgress("Removed snapshot %s", snapshot.ID)
+	}
+
+	// Release repository chunks that only removed snapshots referenced.
+	if err := storage.PruneRepository(destination); err != nil {
+		return fmt.Errorf("failed to prune repository: %w", err)
+	}
+
+	return nil
+}
+
+// Prune applies the retention policy to a destination and releases repository
+// data no snapshot references any more.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - keepDays: The number of days to keep snapshots; negative uses the configured retention.
+
+// Returns:
+// - error: An error if the configuration cannot be loaded or cleanup fails.
+func Prune(destination, configPath string, keepDays int) error {
+	// Fall back to the configured retention period.
+	if keepDays < 0 {
+		config, err := cli.LoadConfig(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		keepDays = config.RetentionDays
+	}
+
+	// Remove expired snapshots.
+	if err := storage.CleanupOldBackups(destination, keepDays); err != nil {
+		return fmt.Errorf("failed to clean up old backups: %w", err)
+	}
+
+	// Release repository chunks no snapshot references, even when nothing expired.
+	if err := storage.PruneRepository(destination); err != nil {
+		return fmt.Errorf("failed to prune repository: %w", err)
+	}
+
+	return nil
+}
+
+// CheckConfig loads a configuration file and validates every setting that can
+// be checked without running a backup.
+
+// Parameters:
+// - configPath: The path to the config file.
+
+// Returns:
+// - error: An error describing every invalid setting.
+func CheckConfig(configPath string) error {
+	// Load the configuration from the specified file.
+	config, err := cli.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Collect every problem rather than stopping at the first.
+	var problems []string
+	if config.RetentionDays < 0 {
+		problems = append(problems, "retention_days must not be negative")
+	}
+	if config.FullEvery < 0 || config.FullEveryDays < 0 {
+		problems = append(problems, "full_every and full_every_days must not be negative")
+	}
+	if _, err := storage.ParseFormat(config.Format); err != nil {
+		problems = append(problems, err.Error())
+	}
+	mode, err := storage.ParseMode(config.Mode)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	encryption := newEncryption(config)
+	if err := encryption.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if encryption.Enabled && mode == storage.ModeRepository {
+		problems = append(problems, "encryption is not supported in repository mode")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration %s:\n  %s", configPath, strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ppriyankuu/goback/internals/cli"
+	"github.com/ppriyankuu/goback/internals/storage"
+)
+
+// ErrVerificationFailed reports that at least one snapshot failed verification.
+var ErrVerificationFailed = errors.New("verification failed")
+
+// Verify checks the archives of snapshots against their catalog checksums and manifests.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - configPath: The path to the config file.
+// - identityFile: The private key file, overriding the configured one when not empty.
+// - snapshotIDs: The IDs or ID prefixes of the snapshots to verify; empty verifies all.
+
+// Returns:
+//   - error: ErrVerificationFailed if any snapshot is damaged, or another error if
+//     the snapshots cannot be found.
+func Verify(destination, configPath, identityFile string, snapshotIDs []string) error {
+	// Load the encryption settings, needed for encrypted archives.
+	_, encryption, err := loadEncryption(configPath, identityFile)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the snapshots to verify.
+	var snapshots []storage.Metadata
+	if len(snapshotIDs) == 0 {
+		if snapshots, err = ListSnapshots(destination); err != nil {
+			return err
+		}
+	}
+	for _, id := range snapshotIDs {
+		snapshot, err := storage.GetMetadata(destination, id)
+		if err != nil {
+			return fmt.Errorf("failed to find snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// Verify each snapshot, reporting every failure before giving up.
+	failed := 0
+	for _, snapshot := range snapshots {
+		if err := storage.VerifySnapshot(snapshot, encryption); err != nil {
+			cli.TrackProgress("Snapshot %s FAILED: %v", snapshot.ID, err)
+			failed++
+			continue
+		}
+		cli.TrackProgress("Snapshot %s OK", snapshot.ID)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d snapshots damaged", ErrVerificationFailed, failed, len(snapshots))
+	}
+
+	return nil
+}
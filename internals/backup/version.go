@@ -19,5 +19,5 @@ func Version() (string, error) {
 	}
 
 	// Format and return the version.
-	return fmt.Sprintf("Backup version: %s (created at: %s)", metadata.Path, metadata.Time), nil
+	return fmt.Sprintf("Backup version: %s %s (created at: %s)", metadata.ID, metadata.Path, metadata.StartTime), nil
 }
@@ -11,6 +11,69 @@ import (
 type Config struct {
 	// RetentionDays specifies how many days to retain data.
 	RetentionDays int `yaml:"retention_days"`
+
+	// Format is the archive format used for new backups (zip, tar, tar.gz or tar.zst).
+	Format string `yaml:"format"`
+
+	// Mode selects the storage mode: "archive" files or a deduplicating "repository".
+	Mode string `yaml:"mode"`
+
+	// FullEvery starts a new chain with a full backup after this many
+	// incremental backups; zero never forces a full backup.
+	FullEvery int `yaml:"full_every"`
+
+	// FullEveryDays starts a new chain with a full backup once the last full
+	// backup is this many days old; zero never forces a full backup.
+	FullEveryDays int `yaml:"full_every_days"`
+
+	// FollowSymlinks backs up the targets of symbolic links instead of the
+	// links themselves, descending into linked directories.
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+
+	// CompareHashes makes incremental backups hash files whose size, times and
+	// inode are unchanged, catching modifications that preserved the metadata.
+	CompareHashes bool `yaml:"compare_hashes"`
+
+	// Xattrs selects the extended attributes and ACLs captured by backups.
+	Xattrs XattrConfig `yaml:"xattrs"`
+
+	// Encryption configures client-side encryption of archives.
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
+// XattrConfig selects extended attributes by name or namespace, e.g. "user",
+// "security.selinux" or "system.posix_acl_access". By default every
+// attribute is captured, including POSIX ACLs.
+type XattrConfig struct {
+	// Include lists the attributes to capture; empty captures all of them.
+	Include []string `yaml:"include"`
+
+	// Exclude lists attributes never to capture, even if included.
+	Exclude []string `yaml:"exclude"`
+}
+
+// EncryptionConfig configures archive encryption and where its passphrase comes from.
+// Sources are tried in order: passphrase_env, passphrase_file, passphrase_command,
+// the GOBACK_PASSPHRASE environment variable and finally an interactive prompt.
+type EncryptionConfig struct {
+	// Enabled encrypts new archives.
+	Enabled bool `yaml:"enabled"`
+
+	// PassphraseEnv names an environment variable holding the passphrase.
+	PassphraseEnv string `yaml:"passphrase_env"`
+
+	// PassphraseFile is the path of a file containing the passphrase.
+	PassphraseFile string `yaml:"passphrase_file"`
+
+	// PassphraseCommand is a shell command printing the passphrase.
+	PassphraseCommand string `yaml:"passphrase_command"`
+
+	// Recipients are X25519 public keys new archives are encrypted to instead
+	// of the passphrase. The backup host then cannot read its own archives.
+	Recipients []string `yaml:"recipients"`
+
+	// IdentityFile is the private key file used to decrypt archives encrypted to recipients.
+	IdentityFile string `yaml:"identity_file"`
 }
 
 // LoadConfig reads and parses the configuration file.
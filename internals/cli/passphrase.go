@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnvVar is the environment variable consulted when no other
+// passphrase source is configured.
+const PassphraseEnvVar = "GOBACK_PASSPHRASE"
+
+// PassphraseSource returns a function that resolves the archive passphrase from
+// the configured sources. The passphrase is resolved at most once, and only
+// when the function is first called, so no prompt appears unless needed.
+
+// Parameters:
+// - config: The encryption configuration naming the passphrase sources.
+
+// Returns:
+// - func() (string, error): The lazy passphrase resolver.
+func PassphraseSource(config EncryptionConfig) func() (string, error) {
+	var (
+		once       sync.Once
+		passphrase string
+		err        error
+	)
+
+	return func() (string, error) {
+		once.Do(func() {
+			passphrase, err = resolvePassphrase(config)
+		})
+		return passphrase, err
+	}
+}
+
+// resolvePassphrase tries each passphrase source in turn.
+
+// Parameters:
+// - config: The encryption configuration naming the passphrase sources.
+
+// Returns:
+// - string: The passphrase.
+// - error: An error if a configured source fails or no source is available.
+func resolvePassphrase(config EncryptionConfig) (string, error) {
+	// An explicitly configured environment variable.
+	if config.PassphraseEnv != "" {
+		if value := os.Getenv(config.PassphraseEnv); value != "" {
+			return value, nil
+		}
+	}
+
+	// A file holding the passphrase, without its trailing newline.
+	if config.PassphraseFile != "" {
+		data, err := os.ReadFile(config.PassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	// A command printing the passphrase, e.g. a password manager.
+	if config.PassphraseCommand != "" {
+		cmd := exec.Command("sh", "-c", config.PassphraseCommand)
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run passphrase command: %w", err)
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+	}
+
+	// The default environment variable.
+	if value := os.Getenv(PassphraseEnvVar); value != "" {
+		return value, nil
+	}
+
+	// Finally, ask on the terminal without echoing the input.
+	return promptPassphrase()
+}
+
+// promptPassphrase reads the passphrase from the terminal.
+
+// Returns:
+// - string: The entered passphrase.
+// - error: An error if standard input is not a terminal or reading fails.
+func promptPassphrase() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no passphrase source configured and standard input is not a terminal")
+	}
+
+	// Print the prompt on stderr so it does not mix with regular output.
+	fmt.Fprint(os.Stderr, "Enter passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+
+	return string(passphrase), nil
+}
@@ -11,3 +11,23 @@ func TrackProgress(message string, args ...any) {
 	fmt.Printf(message, args...)
 	fmt.Println()
 }
+
+// FormatBytes renders a byte count with a binary unit.
+
+// Parameters:
+// - n: The number of bytes.
+
+// Returns:
+// - string: The count, e.g. "512 B" or "1.5 MiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
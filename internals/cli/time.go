@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeLayouts are the accepted layouts of user-supplied points in time.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses a point in time given on the command line. Times without a
+// zone are interpreted in the local time zone.
+
+// Parameters:
+// - value: The time, e.g. "2026-10-01 12:00" or an RFC 3339 timestamp.
+
+// Returns:
+// - time.Time: The parsed time.
+// - error: An error if the value matches none of the accepted layouts.
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a format like \"2006-01-02 15:04\"", value)
+}
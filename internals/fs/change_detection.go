@@ -1,56 +1,246 @@
 package fs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"slices"
+	"io"
+	"os"
 )
 
-// DetectChanges compares the contents of two directories and returns a list of files
-// that are present in the current directory but missing from the previous directory.
+// Changes classifies the entries of a directory tree against the manifest of
+// a previous backup. Entry names are slash-separated paths relative to the root.
+type Changes struct {
+	// Entries describes every entry of the current tree in traversal order.
+	// Unchanged files carry over the content hash and data segments of the
+	// previous backup.
+	Entries []FileEntry
+	// Added lists entries that did not exist in the previous backup.
+	Added []string
+	// Modified lists entries whose type, metadata or contents changed.
+	Modified []string
+	// Unchanged lists entries that are identical to the previous backup.
+	Unchanged []string
+	// Deleted lists entries of the previous backup that no longer exist.
+	Deleted []string
+
+	// paths maps entry names to their path on disk.
+	paths map[string]string
+}
+
+// ChangedPaths returns the paths on disk of all added and modified entries, in traversal order.
+
+// Returns:
+// - []string: The paths to include in an incremental backup.
+func (c *Changes) ChangedPaths() []string {
+	// Collect the names of changed entries.
+	changed := make(map[string]bool, len(c.Added)+len(c.Modified))
+	for _, name := range c.Added {
+		changed[name] = true
+	}
+	for _, name := range c.Modified {
+		changed[name] = true
+	}
+
+	// Keep the traversal order so parents precede their children.
+	var paths []string
+	for _, entry := range c.Entries {
+		if changed[entry.Name] {
+			paths = append(paths, c.paths[entry.Name])
+		}
+	}
+
+	return paths
+}
+
+// DetectChanges walks the source directory and compares every entry with the
+// manifest of the previous backup. An entry is unchanged when its type, size,
+// permissions, owner, link target, extended attributes, modification time,
+// change time and inode all match; with compareHashes set, regular files must also have identical contents.
+// Lookups use a map, so the cost is linear in the size of the tree.
 
 // Parameters:
-// - source: The path to the current directory
-// - previousSource: The path to the previous directory for comparison
+// - source: The path to the current directory.
+// - previous: The entries of the previous backup's manifest.
+// - compareHashes: Whether to hash files whose metadata looks unchanged.
+// - followSymlinks: Whether to describe symbolic links by their target.
+// - xattrs: The filter selecting the extended attributes to record; nil records none.
 
 // Returns:
-// - []string: A slice of strings containing the names of files that have changed or are new.
-// - error: An error if the directory traversal fails for either path.
-func DetectChanges(source, previousSource string) ([]string, error) {
-	// Traverse the current directory and get a list of all files
-	currentFiles, err := TraversalDirectory(source)
+// - *Changes: The classification of every current and previous entry.
+// - error: An error if the directory traversal or hashing fails.
+func DetectChanges(source string, previous []FileEntry, compareHashes, followSymlinks bool, xattrs *XattrFilter) (*Changes, error) {
+	// Traverse the current directory and get a list of all entries.
+	paths, err := TraversalTree(source, followSymlinks)
 	if err != nil {
 		return nil, fmt.Errorf("failed to traverse current directory: %w", err)
 	}
 
-	// Traverse the previous directory and get a list of all files
-	previousFiles, err := TraversalDirectory(previousSource)
-	if err != nil {
-		return nil, fmt.Errorf("failed to traverse previous directory: %w", err)
+	// Index the previous entries by name.
+	index := make(map[string]FileEntry, len(previous))
+	for _, entry := range previous {
+		index[entry.Name] = entry
 	}
 
-	// Initialise a slice to store the names of files that have changed or are new.
-	var changes []string
-	for _, file := range currentFiles {
-		// If the files is not present inside previousFiles, it is considered to be altered
-		if !contains(previousFiles, file) {
-			// Add the new or changed file to the changes slice.
-			changes = append(changes, file)
+	changes := &Changes{paths: make(map[string]string, len(paths))}
+	seen := make(map[string]bool, len(paths))
+	links := NewHardLinks()
+
+	for _, path := range paths {
+		// Describe the current entry.
+		name, err := RelativePath(source, path)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := DescribeFile(path, name, followSymlinks, xattrs)
+		if err != nil {
+			return nil, err
+		}
+		// Further names of a file are described as hard links, as in the archives.
+		links.Link(&entry)
+		changes.paths[name] = path
+		seen[name] = true
+
+		// Classify it against the previous entry of the same name.
+		old, existed := index[name]
+		switch {
+		case !existed:
+			changes.Added = append(changes.Added, name)
+		case !sameMetadata(old, entry):
+			changes.Modified = append(changes.Modified, name)
+		case compareHashes && entry.Type == TypeFile:
+			// Hash the contents to catch changes that preserved the metadata.
+			hash, err := HashFile(path)
+			if err != nil {
+				return nil, err
+			}
+			entry.Hash = hash
+			if hash != old.Hash {
+				changes.Modified = append(changes.Modified, name)
+			} else {
+				entry.Sparse = old.Sparse
+				changes.Unchanged = append(changes.Unchanged, name)
+			}
+		default:
+			entry.Hash, entry.Sparse = old.Hash, old.Sparse
+			changes.Unchanged = append(changes.Unchanged, name)
+		}
+
+		changes.Entries = append(changes.Entries, entry)
+	}
+
+	// Previous entries that were not seen have been deleted.
+	for _, entry := range previous {
+		if !seen[entry.Name] {
+			changes.Deleted = append(changes.Deleted, entry.Name)
 		}
 	}
 
-	// Return the list of changed files
+	// Return the classified changes.
 	return changes, nil
 }
 
-// contains checks if a specific item exists within a slice of strings
+// CompareEntries classifies the entries of one manifest against those of an
+// earlier one without touching the file system. Entries whose metadata match
+// are also compared by content hash when both manifests recorded one.
+
+// Parameters:
+// - previous: The entries of the earlier manifest.
+// - current: The entries of the later manifest.
+
+// Returns:
+// - *Changes: The classification of every entry of both manifests.
+func CompareEntries(previous, current []FileEntry) *Changes {
+	// Index the previous entries by name.
+	index := make(map[string]FileEntry, len(previous))
+	for _, entry := range previous {
+		index[entry.Name] = entry
+	}
+
+	changes := &Changes{Entries: current}
+	seen := make(map[string]bool, len(current))
+
+	for _, entry := range current {
+		seen[entry.Name] = true
+
+		// Classify it against the previous entry of the same name.
+		old, existed := index[entry.Name]
+		switch {
+		case !existed:
+			changes.Added = append(changes.Added, entry.Name)
+		case !sameMetadata(old, entry):
+			changes.Modified = append(changes.Modified, entry.Name)
+		case old.Hash != "" && entry.Hash != "" && old.Hash != entry.Hash:
+			changes.Modified = append(changes.Modified, entry.Name)
+		default:
+			changes.Unchanged = append(changes.Unchanged, entry.Name)
+		}
+	}
+
+	// Previous entries that were not seen have been deleted.
+	for _, entry := range previous {
+		if !seen[entry.Name] {
+			changes.Deleted = append(changes.Deleted, entry.Name)
+		}
+	}
+
+	return changes
+}
+
+// sameMetadata reports whether two descriptions of an entry match closely
+// enough for its contents to be considered unchanged.
+
+// Parameters:
+// - old: The entry recorded by the previous backup.
+// - current: The entry as it is now.
+
+// Returns:
+// - bool: True if nothing indicates a change.
+func sameMetadata(old, current FileEntry) bool {
+	// The basic attributes must match.
+	if old.Type != current.Type || old.Mode != current.Mode ||
+		old.UID != current.UID || old.GID != current.GID ||
+		old.Linkname != current.Linkname || !sameXattrs(old.Xattrs, current.Xattrs) {
+		return false
+	}
+
+	// Sizes are only recorded for regular files, so comparing them is safe for all types.
+	if old.Size != current.Size || !old.ModTime.Equal(current.ModTime) {
+		return false
+	}
+
+	// The change time and inode are only compared when both sides recorded them.
+	if !old.ChangeTime.IsZero() && !current.ChangeTime.IsZero() && !old.ChangeTime.Equal(current.ChangeTime) {
+		return false
+	}
+	if old.Inode != 0 && current.Inode != 0 && old.Inode != current.Inode {
+		return false
+	}
+
+	return true
+}
+
+// HashFile computes the hex-encoded SHA-256 of a file's contents.
 
 // Parameters:
-// - slice: the slice of strings to search within.
-// - item: the string item to look for.
+// - path: The file to hash.
 
 // Returns:
-// - bool: True if the item is found, otherwise false.
-func contains(slice []string, item string) bool {
-	// Use slices package to check for item existence.
-	return slices.Contains(slice, item)
+// - string: The hexadecimal hash.
+// - error: An error if the file cannot be read.
+func HashFile(path string) (string, error) {
+	// Open the file.
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Hash its contents.
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
@@ -3,20 +3,111 @@ package fs
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"syscall"
+	"time"
 )
 
-// GetFileMetaData retrieves metadata for the specified file path.
+// GetFileMetaData retrieves metadata for the specified file path. Symbolic
+// links are described as links unless followSymlinks is set, in which case
+// they are described by their target. Links that dangle or point back to a
+// directory containing them are always described as links, so following them
+// can neither fail nor loop.
 
 // Parameters:
 // - path: The file path for which metadata is to be retrieved.
+// - followSymlinks: Whether to describe symbolic links by their target.
 
 // Returns:
 // - os.FileInfo: The file information, including size, mode, and modifaction time.
 // - error: An error if the file doesn't exist or metadata retrieval fails.
-func GetFileMetadata(path string) (os.FileInfo, error) {
-	// Use os.Stat to get file metadata
-	return os.Stat(path)
+func GetFileMetadata(path string, followSymlinks bool) (os.FileInfo, error) {
+	// Use os.Lstat to get the metadata of the entry itself.
+	info, err := os.Lstat(path)
+	if err != nil || !followSymlinks || info.Mode()&os.ModeSymlink == 0 {
+		return info, err
+	}
+
+	// Dangling links are kept as links.
+	target, err := os.Stat(path)
+	if err != nil {
+		return info, nil
+	}
+
+	// So are links to a directory containing them.
+	if target.IsDir() && isLoop(path) {
+		return info, nil
+	}
+
+	return target, nil
+}
+
+// isLoop reports whether a symbolic link points at one of its own ancestors,
+// so that following it would traverse the same directories forever.
+
+// Parameters:
+// - path: The path of the symbolic link.
+
+// Returns:
+// - bool: True if the link resolves to a directory above it.
+func isLoop(path string) bool {
+	// Resolve the absolute path of the link, and the link itself.
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return false
+	}
+
+	// Compare the target with each resolved ancestor, up to the file system root.
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil && resolved == target {
+			return true
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
+
+// DescribeFile describes a file system entry, including the target of symbolic
+// links and the extended attributes selected by the filter.
+
+// Parameters:
+// - path: The path of the entry on disk.
+// - name: The slash-separated path of the entry relative to the source root.
+// - followSymlinks: Whether to describe symbolic links by their target.
+// - xattrs: The filter selecting the extended attributes to record; nil records none.
+
+// Returns:
+// - FileEntry: The entry, without a content hash.
+// - error: An error if the entry cannot be inspected.
+func DescribeFile(path, name string, followSymlinks bool, xattrs *XattrFilter) (FileEntry, error) {
+	// Get the file information.
+	info, err := GetFileMetadata(path, followSymlinks)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("failed to get file info: %w", err)
+	}
+	entry := NewFileEntry(name, info)
+
+	// Symbolic links are stored with their target.
+	if entry.Type == TypeSymlink {
+		if entry.Linkname, err = os.Readlink(path); err != nil {
+			return FileEntry{}, fmt.Errorf("failed to read symlink: %w", err)
+		}
+	}
+
+	// Record the selected extended attributes of the entry itself, or of the
+	// target of a followed link.
+	if xattrs != nil {
+		if entry.Xattrs, err = readXattrs(path, entry.Type != TypeSymlink, xattrs); err != nil {
+			return FileEntry{}, err
+		}
+	}
+
+	return entry, nil
 }
 
 // SetFileMetaData sets the file metadata such as permissions, timestamps, and ownerships.
@@ -28,26 +119,233 @@ func GetFileMetadata(path string) (os.FileInfo, error) {
 // Returns:
 // - error: An error if any step in setting metadata fails.
 func SetFileMetadata(path string, info os.FileInfo) error {
-	// Set the file mode (permissions)
-	if err := os.Chmod(path, info.Mode()); err != nil {
-		return fmt.Errorf("failed to set file mod: %w", err)
+	return SetEntryMetadata(path, NewFileEntry(filepath.Base(path), info))
+}
+
+// SetEntryMetadata applies the ownership, permissions, extended attributes and
+// timestamps recorded in an entry to the file at path. Ownership is only
+// restored when running as root; other users keep ownership of what they
+// restore. The owner is set first, since changing it clears the setuid and
+// setgid bits and file capabilities, then the extended attributes before the
+// final mode can make the file read-only, and the times last.
+
+// Parameters:
+// - path: The file path for which metadata is to be set.
+// - entry: The entry holding the metadata to apply.
+
+// Returns:
+// - error: An error if any step in setting metadata fails.
+func SetEntryMetadata(path string, entry FileEntry) error {
+	// Set the file ownership (user and group IDs) without following links.
+	if CanRestoreOwnership() {
+		if err := os.Lchown(path, entry.UID, entry.GID); err != nil {
+			return fmt.Errorf("failed to set file ownership: %w", err)
+		}
+	}
+
+	// Hard links share the metadata of the entry they link to.
+	if entry.Type == TypeHardlink {
+		return nil
+	}
+
+	// Symbolic links have no permissions of their own.
+	if entry.Type == TypeSymlink {
+		return writeXattrs(path, entry.Xattrs)
+	}
+
+	// Set the extended attributes while the file is still writable: a
+	// read-only mode would keep an unprivileged user from writing them.
+	if err := writeXattrs(path, entry.Xattrs); err != nil {
+		return err
 	}
 
-	// Set the file modification and access time
-	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+	// Set the file mode, including the setuid, setgid and sticky bits. Its
+	// group bits were recorded as the mask of any access ACL, so the ACL
+	// written above is left as it was.
+	if err := os.Chmod(path, entry.Mode); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+
+	// Set the file access and modification times, falling back to the
+	// modification time where no access time was recorded.
+	atime := entry.AccessTime
+	if atime.IsZero() {
+		atime = entry.ModTime
+	}
+	if err := os.Chtimes(path, atime, entry.ModTime); err != nil {
 		return fmt.Errorf("failed to set file times: %w", err)
 	}
 
+	return nil
+}
+
+// CanRestoreOwnership reports whether restored files can be given their
+// recorded owner, which requires running as root.
+
+// Returns:
+// - bool: True if the process runs as root.
+func CanRestoreOwnership() bool {
+	return os.Geteuid() == 0
+}
+
+// FileType identifies the kind of a file system entry.
+type FileType string
+
+const (
+	// TypeFile is a regular file.
+	TypeFile FileType = "file"
+	// TypeDir is a directory.
+	TypeDir FileType = "dir"
+	// TypeSymlink is a symbolic link.
+	TypeSymlink FileType = "symlink"
+	// TypeHardlink is an additional name for an already stored file.
+	TypeHardlink FileType = "hardlink"
+	// TypeCharDevice is a character device node.
+	TypeCharDevice FileType = "chardev"
+	// TypeBlockDevice is a block device node.
+	TypeBlockDevice FileType = "blockdev"
+	// TypeFIFO is a named pipe.
+	TypeFIFO FileType = "fifo"
+	// TypeSocket is a Unix domain socket.
+	TypeSocket FileType = "socket"
+)
+
+// FileTypeOf returns the file type described by the given file information.
+
+// Parameters:
+// - info: The os.FileInfo to inspect.
+
+// Returns:
+// - FileType: The type of the file.
+func FileTypeOf(info os.FileInfo) FileType {
+	// Map the type bits of the file mode onto a FileType.
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return TypeDir
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&os.ModeNamedPipe != 0:
+		return TypeFIFO
+	case mode&os.ModeSocket != 0:
+		return TypeSocket
+	case mode&os.ModeCharDevice != 0:
+		return TypeCharDevice
+	case mode&os.ModeDevice != 0:
+		return TypeBlockDevice
+	default:
+		return TypeFile
+	}
+}
+
+// Ownership returns the numeric owner and group recorded in the file information.
+
+// Parameters:
+// - info: The os.FileInfo to inspect.
+
+// Returns:
+// - int: The user ID of the owner, or 0 if unavailable.
+// - int: The group ID of the owner, or 0 if unavailable.
+func Ownership(info os.FileInfo) (int, int) {
 	// Retrieve system-specific file metadata for ownership information.
 	stat, ok := info.Sys().(*syscall.Stat_t)
 	if !ok {
-		return fmt.Errorf("failed to retrieve file ownership information")
+		return 0, 0
+	}
+
+	return int(stat.Uid), int(stat.Gid)
+}
+
+// FileEntry describes a single file system entry of a backup, as stored in
+// archives and snapshot manifests.
+type FileEntry struct {
+	// Name is the slash-separated path relative to the source root.
+	Name string `json:"name"`
+	// Type is the kind of file system entry.
+	Type FileType `json:"type"`
+	// Mode holds the permission bits, including setuid, setgid and sticky.
+	Mode os.FileMode `json:"mode"`
+	// Size is the length of the contents of regular files.
+	Size int64 `json:"size,omitempty"`
+	// Sparse lists the data segments of sparse files; holes are not stored.
+	Sparse []Segment `json:"sparse,omitempty"`
+	// ModTime is the last modification time.
+	ModTime time.Time `json:"mtime"`
+	// AccessTime is the last access time, restored but never compared.
+	AccessTime time.Time `json:"atime"`
+	// UID and GID are the numeric owner and group.
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	// Linkname is the target of symbolic and hard links.
+	Linkname string `json:"linkname,omitempty"`
+	// DevMajor and DevMinor are the device numbers of device nodes.
+	DevMajor int64 `json:"devmajor,omitempty"`
+	DevMinor int64 `json:"devminor,omitempty"`
+	// Xattrs holds the extended attributes by name, including POSIX ACLs.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+	// Hash is the hex-encoded SHA-256 of the contents of regular files.
+	Hash string `json:"sha256,omitempty"`
+	// ChangeTime, Inode and Device identify the file on its file system
+	// and are used to detect changes between backups.
+	ChangeTime time.Time `json:"ctime"`
+	Inode      uint64    `json:"inode,omitempty"`
+	Device     uint64    `json:"dev,omitempty"`
+	// Links is the number of hard links to the file.
+	Links uint64 `json:"nlink,omitempty"`
+}
+
+// NewFileEntry describes a file system entry from its file information.
+
+// Parameters:
+// - name: The slash-separated path of the entry relative to the source root.
+// - info: The os.FileInfo of the entry.
+
+// Returns:
+// - FileEntry: The entry, without a content hash.
+func NewFileEntry(name string, info os.FileInfo) FileEntry {
+	entry := FileEntry{
+		Name:    name,
+		Type:    FileTypeOf(info),
+		Mode:    info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+		ModTime: info.ModTime(),
 	}
+	entry.UID, entry.GID = Ownership(info)
+	entry.ChangeTime, entry.Inode, entry.Device = statDetails(info)
+	entry.Links = linkCount(info)
+	entry.AccessTime = accessTime(info)
 
-	// Set the file onwership (user and group IDs)
-	if err := os.Lchown(path, int(stat.Uid), int(stat.Gid)); err != nil {
-		return fmt.Errorf("failed to set file ownership: %w", err)
+	// Only regular files have a size worth recording, and only device nodes
+	// device numbers.
+	switch entry.Type {
+	case TypeFile:
+		entry.Size = info.Size()
+	case TypeCharDevice, TypeBlockDevice:
+		entry.DevMajor, entry.DevMinor = deviceNumbers(info)
 	}
 
-	return nil
+	return entry
+}
+
+// FileMode returns the mode of the entry including its type bits, as
+// os.Lstat would report it. Hard links are regular files.
+
+// Returns:
+// - os.FileMode: The permission and type bits.
+func (e FileEntry) FileMode() os.FileMode {
+	switch e.Type {
+	case TypeDir:
+		return e.Mode | os.ModeDir
+	case TypeSymlink:
+		return e.Mode | os.ModeSymlink
+	case TypeFIFO:
+		return e.Mode | os.ModeNamedPipe
+	case TypeSocket:
+		return e.Mode | os.ModeSocket
+	case TypeCharDevice:
+		return e.Mode | os.ModeDevice | os.ModeCharDevice
+	case TypeBlockDevice:
+		return e.Mode | os.ModeDevice
+	}
+
+	return e.Mode
 }
@@ -0,0 +1,75 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetEntryMetadataReadOnlyWithXattrs(t *testing.T) {
+	if CanRestoreOwnership() {
+		t.Skip("root may write extended attributes of read-only files; run as another user")
+	}
+
+	dir := t.TempDir()
+
+	// Skip file systems without user extended attributes.
+	probe := filepath.Join(dir, "probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(probe, "user.probe", []byte("1"), 0); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("file system does not support user extended attributes")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		path  string
+		entry FileEntry
+	}{
+		{file, FileEntry{Name: "file", Type: TypeFile, Mode: 0444, ModTime: mtime, Xattrs: map[string][]byte{"user.k": []byte("v")}}},
+		{sub, FileEntry{Name: "sub", Type: TypeDir, Mode: os.ModeDir | 0555, ModTime: mtime, Xattrs: map[string][]byte{"user.k": []byte("v")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry.Name, func(t *testing.T) {
+			if err := SetEntryMetadata(tt.path, tt.entry); err != nil {
+				t.Fatalf("SetEntryMetadata: %v", err)
+			}
+
+			// The attribute, the read-only mode and the time must all be in place.
+			value := make([]byte, 16)
+			n, err := unix.Getxattr(tt.path, "user.k", value)
+			if err != nil || string(value[:n]) != "v" {
+				t.Errorf("user.k = %q, %v; want %q", value[:n], err, "v")
+			}
+			info, err := os.Stat(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.entry.Mode.Perm() {
+				t.Errorf("mode = %v, want %v", info.Mode().Perm(), tt.entry.Mode.Perm())
+			}
+			if !info.ModTime().Equal(mtime) {
+				t.Errorf("modification time = %v, want %v", info.ModTime(), mtime)
+			}
+		})
+	}
+
+	// Let the temporary directory be cleaned up.
+	if err := os.Chmod(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathFilter selects entries of a snapshot by their slash-separated names.
+// Paths select an entry and everything below it. Patterns are globs matched
+// against whole names, where "**" stands for any number of directories; a
+// pattern without a slash matches the base name at any depth, so "*.log"
+// matches every log file. Excluding a directory excludes its contents.
+type PathFilter struct {
+	// Paths lists entries to select together with everything below them.
+	Paths []string
+	// Include lists patterns of entries to select.
+	Include []string
+	// Exclude lists patterns of entries never to select, even if included.
+	Exclude []string
+}
+
+// NewPathFilter creates a filter from user supplied paths and patterns,
+// normalising them into the form of entry names.
+
+// Parameters:
+// - paths: The entries to select with everything below them.
+// - include: The patterns of entries to select.
+// - exclude: The patterns of entries never to select.
+
+// Returns:
+// - *PathFilter: The filter.
+// - error: An error if a pattern is malformed.
+func NewPathFilter(paths, include, exclude []string) (*PathFilter, error) {
+	filter := &PathFilter{}
+
+	// Entry names are relative, so strip leading and trailing slashes.
+	for _, p := range paths {
+		filter.Paths = append(filter.Paths, strings.Trim(path.Clean("/"+p), "/"))
+	}
+
+	// Reject malformed patterns up front rather than silently matching nothing.
+	var err error
+	if filter.Include, err = cleanPatterns(include); err != nil {
+		return nil, err
+	}
+	if filter.Exclude, err = cleanPatterns(exclude); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// Selective reports whether the filter narrows the selection at all.
+
+// Returns:
+// - bool: True if any path or pattern was given.
+func (f *PathFilter) Selective() bool {
+	return len(f.Paths) > 0 || len(f.Include) > 0 || len(f.Exclude) > 0
+}
+
+// Match reports whether the filter selects the named entry.
+
+// Parameters:
+// - name: The slash-separated name of the entry.
+
+// Returns:
+// - bool: True if the entry should be selected.
+func (f *PathFilter) Match(name string) bool {
+	// Exclusions take precedence, and apply to everything below a match.
+	for _, pattern := range f.Exclude {
+		for dir := name; dir != "."; dir = path.Dir(dir) {
+			if matchPath(pattern, dir) {
+				return false
+			}
+		}
+	}
+
+	// Without paths or inclusions, everything else is selected.
+	if len(f.Paths) == 0 && len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Paths {
+		if p == "" || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	for _, pattern := range f.Include {
+		if matchPath(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cleanPatterns strips leading and trailing slashes from patterns and checks
+// that they are well formed.
+func cleanPatterns(patterns []string) ([]string, error) {
+	var cleaned []string
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		cleaned = append(cleaned, pattern)
+	}
+	return cleaned, nil
+}
+
+// matchPath reports whether an entry name matches a pattern, comparing only
+// the base name when the pattern has no slash.
+func matchPath(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches the components of a name against those of a pattern,
+// letting "**" stand for any number of components, including none.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		// Try every number of components for "**".
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		// Other components match one component each.
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
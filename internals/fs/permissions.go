@@ -15,7 +15,7 @@ import (
 // - error: An error if any step in preserving metadata fails.
 func PreservePermissions(source, destination string) error {
 	// Retrive metadata of the source file.
-	srcInfo, err := GetFileMetadata(source)
+	srcInfo, err := GetFileMetadata(source, true)
 	if err != nil {
 		return fmt.Errorf("failed to get source file info: %w", err)
 	}
@@ -0,0 +1,201 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Segment is a range of a sparse file holding data; everything between
+// segments is a hole that reads as zeros.
+type Segment struct {
+	Offset int64 `json:"off"`
+	Length int64 `json:"len"`
+}
+
+// sparseReader expands the data of a sparse file into its full contents,
+// producing zeros for the holes without reading anything for them.
+type sparseReader struct {
+	data     io.Reader
+	segments []Segment
+	size     int64
+	offset   int64
+}
+
+// NewSparseReader returns a reader over the full contents of a sparse file.
+
+// Parameters:
+// - data: The bytes of the data segments, one after the other.
+// - segments: The data segments of the file, in order.
+// - size: The size of the file.
+
+// Returns:
+// - io.Reader: The reader producing size bytes, with zeros for the holes.
+func NewSparseReader(data io.Reader, segments []Segment, size int64) io.Reader {
+	return &sparseReader{data: data, segments: segments, size: size}
+}
+
+// SparseFileReader returns a reader over the full contents of a sparse file
+// on disk that only reads its data segments.
+
+// Parameters:
+// - file: The open file.
+// - segments: The data segments of the file, as returned by DataSegments.
+// - size: The size of the file.
+
+// Returns:
+// - io.Reader: The reader producing size bytes, with zeros for the holes.
+func SparseFileReader(file io.ReaderAt, segments []Segment, size int64) io.Reader {
+	sections := make([]io.Reader, len(segments))
+	for i, segment := range segments {
+		sections[i] = io.NewSectionReader(file, segment.Offset, segment.Length)
+	}
+	return NewSparseReader(io.MultiReader(sections...), segments, size)
+}
+
+// Read reads the next bytes of the file, from the data or as zeros.
+func (r *sparseReader) Read(p []byte) (int, error) {
+	// Stop at the end of the file.
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	// Drop the segments already read.
+	for len(r.segments) > 0 && r.offset >= r.segments[0].Offset+r.segments[0].Length {
+		r.segments = r.segments[1:]
+	}
+
+	// Produce zeros up to the next segment, or the end of the file.
+	if len(r.segments) == 0 || r.offset < r.segments[0].Offset {
+		next := r.size
+		if len(r.segments) > 0 {
+			next = r.segments[0].Offset
+		}
+		if int64(len(p)) > next-r.offset {
+			p = p[:next-r.offset]
+		}
+		clear(p)
+		r.offset += int64(len(p))
+		return len(p), nil
+	}
+
+	// Read the data of the current segment.
+	if end := r.segments[0].Offset + r.segments[0].Length; int64(len(p)) > end-r.offset {
+		p = p[:end-r.offset]
+	}
+	n, err := r.data.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF {
+		if n == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// dataReader reads only the data segments out of the full contents of a sparse file.
+type dataReader struct {
+	contents io.Reader
+	segments []Segment
+	offset   int64
+}
+
+// SparseData returns a reader over the data segments of a sparse file, one
+// after the other, skipping the holes of its full contents.
+
+// Parameters:
+// - contents: The full contents of the file.
+// - segments: The data segments of the file.
+
+// Returns:
+// - io.Reader: The reader over the bytes of the data segments.
+func SparseData(contents io.Reader, segments []Segment) io.Reader {
+	return &dataReader{contents: contents, segments: segments}
+}
+
+// Read reads the next bytes of the current data segment.
+func (r *dataReader) Read(p []byte) (int, error) {
+	for len(r.segments) > 0 {
+		segment := r.segments[0]
+
+		// Skip the hole before the segment.
+		if err := r.skip(segment.Offset); err != nil {
+			return 0, err
+		}
+
+		// Read from the segment until it is exhausted.
+		if end := segment.Offset + segment.Length; r.offset < end {
+			if int64(len(p)) > end-r.offset {
+				p = p[:end-r.offset]
+			}
+			n, err := r.contents.Read(p)
+			r.offset += int64(n)
+			if err == io.EOF {
+				if n == 0 {
+					return 0, io.ErrUnexpectedEOF
+				}
+				err = nil
+			}
+			return n, err
+		}
+		r.segments = r.segments[1:]
+	}
+
+	return 0, io.EOF
+}
+
+// skip discards the contents up to the given offset.
+func (r *dataReader) skip(offset int64) error {
+	if r.offset >= offset {
+		return nil
+	}
+	n, err := io.CopyN(io.Discard, r.contents, offset-r.offset)
+	r.offset += n
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteSparse writes the full contents of a sparse file into a new, empty
+// file, writing only its data segments so the holes stay unallocated.
+
+// Parameters:
+// - file: The file to write.
+// - contents: The full contents of the file.
+// - segments: The data segments of the file.
+// - size: The size of the file.
+
+// Returns:
+// - error: An error if the contents cannot be read or written.
+func WriteSparse(file *os.File, contents io.Reader, segments []Segment, size int64) error {
+	data := &dataReader{contents: contents}
+	for _, segment := range segments {
+		// Skip the hole before the segment.
+		if err := data.skip(segment.Offset); err != nil {
+			return fmt.Errorf("failed to read sparse file: %w", err)
+		}
+
+		// Write the data at its offset.
+		n, err := io.CopyN(io.NewOffsetWriter(file, segment.Offset), contents, segment.Length)
+		data.offset += n
+		if err != nil {
+			return fmt.Errorf("failed to write sparse file: %w", err)
+		}
+	}
+
+	// Consume the trailing hole and give the file its full size.
+	if err := data.skip(size); err != nil {
+		return fmt.Errorf("failed to read sparse file: %w", err)
+	}
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to set size of sparse file: %w", err)
+	}
+
+	return nil
+}
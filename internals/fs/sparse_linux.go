@@ -0,0 +1,81 @@
+//go:build linux
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DataSegments finds the data segments of a sparse file using SEEK_DATA and
+// SEEK_HOLE. Files that occupy as many blocks as their size suggests are
+// not sparse and are not inspected further. The last segment always ends at
+// the size of the file, so a trailing hole is kept.
+
+// Parameters:
+// - file: The open file to inspect; its offset is reset to the start.
+
+// Returns:
+// - []Segment: The data segments, or nil if the file is not sparse.
+// - error: An error if the file cannot be inspected.
+func DataSegments(file *os.File) ([]Segment, error) {
+	// Compare the allocated blocks with the size of the file.
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	size := info.Size()
+	if !ok || size == 0 || stat.Blocks*512 >= size {
+		return nil, nil
+	}
+
+	// Alternate between the start of the next data and the hole that ends it.
+	var segments []Segment
+	for offset := int64(0); offset < size; {
+		data, err := file.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// Only a hole remains.
+			break
+		}
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTSUP) {
+			// The file system cannot report holes.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find data: %w", err)
+		}
+		hole, err := file.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find hole: %w", err)
+		}
+		hole = min(hole, size)
+		if data >= hole {
+			break
+		}
+		segments = append(segments, Segment{Offset: data, Length: hole - data})
+		offset = hole
+	}
+
+	// Rewind the file for reading.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	// A single segment covering the whole file means there are no holes.
+	if len(segments) == 1 && segments[0].Offset == 0 && segments[0].Length == size {
+		return nil, nil
+	}
+
+	// Mark the end of the file with an empty segment unless data reaches it.
+	if n := len(segments); n == 0 || segments[n-1].Offset+segments[n-1].Length < size {
+		segments = append(segments, Segment{Offset: size})
+	}
+
+	return segments, nil
+}
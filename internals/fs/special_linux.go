@@ -0,0 +1,41 @@
+//go:build linux
+
+package fs
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// CreateSpecialFile recreates a named pipe or device node described by an
+// entry. Its permissions, ownership and times are left to SetEntryMetadata.
+
+// Parameters:
+// - path: The path of the file to create, which must not exist.
+// - entry: The entry describing the file.
+
+// Returns:
+// - error: An error if the entry is not a special file or it cannot be created.
+func CreateSpecialFile(path string, entry FileEntry) error {
+	// Pick the file type bits of the node.
+	var mode uint32
+	switch entry.Type {
+	case TypeFIFO:
+		mode = unix.S_IFIFO
+	case TypeCharDevice:
+		mode = unix.S_IFCHR
+	case TypeBlockDevice:
+		mode = unix.S_IFBLK
+	default:
+		return fmt.Errorf("%s is not a special file", entry.Name)
+	}
+
+	// Create the node with owner-only permissions until its metadata is applied.
+	dev := unix.Mkdev(uint32(entry.DevMajor), uint32(entry.DevMinor))
+	if err := unix.Mknod(path, mode|0600, int(dev)); err != nil {
+		return fmt.Errorf("failed to create %s %s: %w", entry.Type, entry.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fs
+
+import "fmt"
+
+// CreateSpecialFile reports that named pipes and device nodes cannot be
+// recreated on platforms other than Linux.
+func CreateSpecialFile(path string, entry FileEntry) error {
+	return fmt.Errorf("cannot restore %s entry %s on this platform", entry.Type, entry.Name)
+}
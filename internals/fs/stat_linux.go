@@ -0,0 +1,83 @@
+//go:build linux
+
+package fs
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// statDetails returns the change time, inode and device number recorded in the file information.
+
+// Parameters:
+// - info: The os.FileInfo to inspect.
+
+// Returns:
+// - time.Time: The inode change time, or the zero time if unavailable.
+// - uint64: The inode number, or 0 if unavailable.
+// - uint64: The device number of the containing file system, or 0 if unavailable.
+func statDetails(info os.FileInfo) (time.Time, uint64, uint64) {
+	// Retrieve system-specific file metadata.
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, 0, 0
+	}
+
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec)), uint64(stat.Ino), uint64(stat.Dev)
+}
+
+// linkCount returns the number of hard links recorded in the file information.
+
+// Parameters:
+// - info: The os.FileInfo to inspect.
+
+// Returns:
+// - uint64: The number of hard links, or 0 if unavailable.
+func linkCount(info os.FileInfo) uint64 {
+	// Retrieve system-specific file metadata.
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+
+	return uint64(stat.Nlink)
+}
+
+// accessTime returns the last access time recorded in the file information.
+
+// Parameters:
+// - info: The os.FileInfo to inspect.
+
+// Returns:
+// - time.Time: The access time, or the zero time if unavailable.
+func accessTime(info os.FileInfo) time.Time {
+	// Retrieve system-specific file metadata.
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+}
+
+// deviceNumbers returns the major and minor numbers of a device node recorded in the file information.
+
+// Parameters:
+// - info: The os.FileInfo to inspect.
+
+// Returns:
+// - int64: The major device number, or 0 if unavailable.
+// - int64: The minor device number, or 0 if unavailable.
+func deviceNumbers(info os.FileInfo) (int64, int64) {
+	// Retrieve system-specific file metadata.
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+
+	rdev := uint64(stat.Rdev)
+	return int64(unix.Major(rdev)), int64(unix.Minor(rdev))
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package fs
+
+import (
+	"os"
+	"time"
+)
+
+// statDetails returns zero values on platforms where the change time, inode
+// and device number are not collected. Change detection then relies on the
+// size, modification time and, optionally, content hashes.
+func statDetails(info os.FileInfo) (time.Time, uint64, uint64) {
+	return time.Time{}, 0, 0
+}
+
+// linkCount returns 0 on platforms where link counts are not collected, so
+// every file is stored with its own contents.
+func linkCount(info os.FileInfo) uint64 {
+	return 0
+}
+
+// accessTime returns the zero time on platforms where access times are not
+// collected; restores then use the modification time instead.
+func accessTime(info os.FileInfo) time.Time {
+	return time.Time{}
+}
+
+// deviceNumbers returns zero values on platforms where device numbers are not collected.
+func deviceNumbers(info os.FileInfo) (int64, int64) {
+	return 0, 0
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/ppriyankuu/goback/internals/cli"
 )
 
 // TraversalDirectory walks through the given directory and collects
@@ -40,3 +42,153 @@ func TraversalDirectory(dir string) ([]string, error) {
 	// Return the list of the file paths
 	return files, nil
 }
+
+// TraversalTree walks through the given directory and collects the paths
+// of all files and sub-directories, excluding the root directory itself.
+// Parents are always listed before their children, and sockets are skipped
+// with a warning. Symbolic links are listed as entries of their own; with
+// followSymlinks set, links to directories are descended into unless they
+// dangle or loop back to an ancestor.
+
+// Parameters:
+// - dir: The directory path to traverse.
+// - followSymlinks: Whether to descend into symbolic links to directories.
+
+// Returns:
+// - []string: A slice containing the paths of all files and directories found.
+// - error: An error if the traversal fails.
+func TraversalTree(dir string, followSymlinks bool) ([]string, error) {
+	// The root was named explicitly, so it is followed even if it is a link.
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to traverse directory: %w", err)
+	}
+
+	// A single file is its own tree.
+	if !info.IsDir() {
+		return []string{dir}, nil
+	}
+
+	// Walk the directory and record every entry below the root.
+	var paths []string
+	if err := walkTree(dir, followSymlinks, &paths); err != nil {
+		return nil, fmt.Errorf("failed to traverse directory: %w", err)
+	}
+
+	// Return the list of paths.
+	return paths, nil
+}
+
+// walkTree appends the entries below a directory to paths, depth first and in
+// lexical order.
+
+// Parameters:
+// - dir: The directory to list.
+// - followSymlinks: Whether to descend into symbolic links to directories.
+// - paths: The list the paths are appended to.
+
+// Returns:
+// - error: An error if a directory cannot be read or an entry inspected.
+func walkTree(dir string, followSymlinks bool, paths *[]string) error {
+	// List the directory, sorted by name.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := GetFileMetadata(path, followSymlinks)
+		if err != nil {
+			return err
+		}
+
+		// Sockets only exist while a process listens on them, so they cannot be restored.
+		if info.Mode()&os.ModeSocket != 0 {
+			cli.TrackProgress("Skipping socket %s: sockets cannot be backed up", path)
+			continue
+		}
+		*paths = append(*paths, path)
+
+		// Descend into directories, and into links to them when following links.
+		if info.IsDir() {
+			if err := walkTree(path, followSymlinks, paths); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// HardLinks groups regular files by device and inode while entries are
+// described in traversal order. The first name of a file keeps its contents;
+// every further name of the same file becomes a hard link to it.
+type HardLinks struct {
+	names map[[2]uint64]string
+}
+
+// NewHardLinks creates an empty hard link grouping.
+
+// Returns:
+// - *HardLinks: The grouping, to be fed every entry of one archive in order.
+func NewHardLinks() *HardLinks {
+	return &HardLinks{names: make(map[[2]uint64]string)}
+}
+
+// Link turns the entry into a hard link if another name of the same file was
+// seen before, and remembers it as the first name of its file otherwise.
+
+// Parameters:
+// - entry: The entry to inspect, rewritten in place when it is a further name.
+
+// Returns:
+// - bool: True if the entry was turned into a hard link.
+func (h *HardLinks) Link(entry *FileEntry) bool {
+	// Only regular files with more than one name can be hard links.
+	if entry.Type != TypeFile || entry.Links < 2 || entry.Inode == 0 {
+		return false
+	}
+
+	// Remember the first name of every file.
+	key := [2]uint64{entry.Device, entry.Inode}
+	first, ok := h.names[key]
+	if !ok {
+		h.names[key] = entry.Name
+		return false
+	}
+
+	// Further names refer to the first one instead of storing the contents again.
+	entry.Type = TypeHardlink
+	entry.Linkname = first
+	entry.Size = 0
+	entry.Hash = ""
+	return true
+}
+
+// RelativePath returns the slash-separated path of the given path relative
+// to the root. When the root is the path itself (a single file backup),
+// the base name is returned instead.
+
+// Parameters:
+// - root: The root directory the path should be relative to.
+// - path: The path to convert.
+
+// Returns:
+// - string: The slash-separated relative path.
+// - error: An error if the path cannot be made relative to the root.
+func RelativePath(root, path string) (string, error) {
+	// Compute the path relative to the root.
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute relative path: %w", err)
+	}
+
+	// A root that is a file resolves to ".", so fall back to its name.
+	if rel == "." {
+		rel = filepath.Base(path)
+	}
+
+	// Archive entries always use forward slashes.
+	return filepath.ToSlash(rel), nil
+}
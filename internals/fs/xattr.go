@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"bytes"
+	"strings"
+)
+
+// XattrFilter selects the extended attributes captured during backups by
+// name or namespace. A pattern matches an attribute of the same name and every
+// attribute below it, so "user" matches "user.comment" and
+// "system.posix_acl_access" matches only the access ACL.
+type XattrFilter struct {
+	// Include lists the attributes to capture; empty captures all of them.
+	Include []string
+	// Exclude lists attributes never to capture, even if included.
+	Exclude []string
+}
+
+// Match reports whether the filter selects the named attribute.
+
+// Parameters:
+// - name: The full name of the attribute, e.g. "security.selinux".
+
+// Returns:
+// - bool: True if the attribute should be captured.
+func (f *XattrFilter) Match(name string) bool {
+	// Exclusions take precedence.
+	for _, pattern := range f.Exclude {
+		if matchXattr(pattern, name) {
+			return false
+		}
+	}
+
+	// Without inclusions, everything else is captured.
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matchXattr(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchXattr reports whether an attribute name equals a pattern or lies below it.
+func matchXattr(pattern, name string) bool {
+	pattern = strings.TrimSuffix(pattern, ".")
+	return name == pattern || strings.HasPrefix(name, pattern+".")
+}
+
+// sameXattrs reports whether two sets of extended attributes are identical.
+
+// Parameters:
+// - old: The attributes recorded by the previous backup.
+// - current: The attributes as they are now.
+
+// Returns:
+// - bool: True if both hold the same names and values.
+func sameXattrs(old, current map[string][]byte) bool {
+	if len(old) != len(current) {
+		return false
+	}
+	for name, value := range old {
+		if other, ok := current[name]; !ok || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs reads the extended attributes of a file selected by the filter.
+// POSIX ACLs are included as the system.posix_acl_access and
+// system.posix_acl_default attributes.
+
+// Parameters:
+// - path: The path of the file.
+// - follow: Whether to read the attributes of a symbolic link's target rather than the link.
+// - filter: The filter selecting the attributes to read.
+
+// Returns:
+// - map[string][]byte: The attribute values by name, or nil if there are none.
+// - error: An error if the attributes cannot be read.
+func readXattrs(path string, follow bool, filter *XattrFilter) (map[string][]byte, error) {
+	list, get := unix.Llistxattr, unix.Lgetxattr
+	if follow {
+		list, get = unix.Listxattr, unix.Getxattr
+	}
+
+	// List the attribute names; file systems without xattr support have none.
+	names, err := readXattrBuffer(func(dest []byte) (int, error) {
+		return list(path, dest)
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+
+	// Read the value of every selected attribute.
+	var xattrs map[string][]byte
+	for _, name := range strings.Split(string(bytes.TrimRight(names, "\x00")), "\x00") {
+		if name == "" || !filter.Match(name) {
+			continue
+		}
+		value, err := readXattrBuffer(func(dest []byte) (int, error) {
+			return get(path, name, dest)
+		})
+		if errors.Is(err, unix.ENODATA) {
+			// The attribute was removed in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read extended attribute %s: %w", name, err)
+		}
+		if xattrs == nil {
+			xattrs = make(map[string][]byte)
+		}
+		xattrs[name] = value
+	}
+
+	return xattrs, nil
+}
+
+// readXattrBuffer calls a listxattr or getxattr style function, first to
+// learn the size of the result and then to read it, retrying if it grew.
+func readXattrBuffer(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		// Ask for the size of the result.
+		size, err := read(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		// Read it, starting over if it grew in the meantime.
+		buf := make([]byte, size)
+		size, err = read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
+
+// writeXattrs sets extended attributes on a restored file without following
+// symbolic links. The trusted and security namespaces need privileges, so they
+// are only restored when running as root, and file systems without xattr
+// support are left alone.
+
+// Parameters:
+// - path: The path of the restored file.
+// - xattrs: The attribute values by name.
+
+// Returns:
+// - error: An error if an attribute cannot be set.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	root := CanRestoreOwnership()
+	for name, value := range xattrs {
+		// Skip privileged namespaces for other users.
+		if !root && (matchXattr("trusted", name) || matchXattr("security", name)) {
+			continue
+		}
+
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			if errors.Is(err, unix.ENOTSUP) {
+				return nil
+			}
+			return fmt.Errorf("failed to set extended attribute %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fs
+
+// readXattrs reports no extended attributes on platforms where they are not collected.
+func readXattrs(path string, follow bool, filter *XattrFilter) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs ignores extended attributes on platforms where they are not restored.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	return nil
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
-	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -12,256 +15,571 @@ import (
 	"github.com/ppriyankuu/goback/internals/fs"
 )
 
-// CreateArchive creates a zip archive of the specified source directory.
+// CreateArchive creates an archive of the specified source directory.
 
 // Parameters:
 // - destination: The directory where the archive file will be saved.
 // - source: The root directory to be archived.
-// - incremental: A boolean indicating if the archive should be incremental (currently used).
+// - followSymlinks: Whether to archive symbolic links as their target.
+// - xattrs: The filter selecting the extended attributes to archive; nil archives none.
+// - format: The container format of the archive.
+// - encryption: The encryption settings; nil or disabled writes a plaintext archive.
 
 // Returns:
 // - string: The path to the created archive file.
+// - *Manifest: The manifest of the entries written.
 // - error: An error if the archive creation fails.
-func CreateArchive(destination, source string, incremental bool) (string, error) {
-	// Generate a timestamp for the archive file name.
-	timestamp := time.Now().Format("20060102150405")
-	archivePath := filepath.Join(destination, fmt.Sprintf("backup_%s.zip", timestamp))
-
-	// Create the archive file.
-	file, err := os.Create(archivePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create archive file: %w", err)
-	}
-	defer file.Close()
-
-	// Initialise the zip writer.
-	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
-
-	// Traverse the source directory to get a list of files.
-	files, err := fs.TraversalDirectory(source)
+func CreateArchive(destination, source string, followSymlinks bool, xattrs *fs.XattrFilter, format Format, encryption *Encryption) (string, *Manifest, error) {
+	// Traverse the source directory to get a list of files and directories.
+	paths, err := fs.TraversalTree(source, followSymlinks)
 	if err != nil {
-		return "", fmt.Errorf("failed to traverse directory: %w", err)
+		return "", nil, fmt.Errorf("failed to traverse directory: %w", err)
 	}
 
-	// Iterate over each file in the soruce directory.
-	for _, file := range files {
-		// Get file information
-		info, err := fs.GetFileMetadata(file)
-		if err != nil {
-			return "", fmt.Errorf("failed to get file info: %w", err)
-		}
-
-		// Create a zip header based on the file info.
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return "", fmt.Errorf("failed to get file info header: %w", err)
-		}
+	// Write every entry of the source directory into a new archive.
+	return writeArchive(destination, "backup", format, encryption, func(archiver Archiver) (*Manifest, error) {
+		return writeEntries(archiver, source, paths, nil, followSymlinks, xattrs, true)
+	})
+}
 
-		// Set the header name to the file's base name.
-		header.Name = filepath.Base(file)
+// CreateIncrementalArchive creates an archive containing only the specified changed files.
+// Paths deleted since the previous snapshot are recorded as tombstones in the
+// archive's manifest, so restores can remove them again.
 
-		// Create a writer for the zip file.
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return "", fmt.Errorf("failed to create zip header: %w", err)
-		}
+// Parameters:
+// - destination: The directory where the archive file will be saved.
+// - source: The root directory of the files to be archived.
+// - changes: A list of file paths that have changed and need to be archived.
+// - deleted: The entry names deleted since the previous snapshot.
+// - followSymlinks: Whether to archive symbolic links as their target.
+// - xattrs: The filter selecting the extended attributes to archive; nil archives none.
+// - format: The container format of the archive.
+// - encryption: The encryption settings; nil or disabled writes a plaintext archive.
 
-		// Open the source file.
-		src, err := os.Open(file)
-		if err != nil {
-			return "", fmt.Errorf("failed to open source file: %w", err)
-		}
+// Returns:
+// - string: The path to the created archive file.
+// - *Manifest: The manifest of the entries written.
+// - error: An error if the archive creation fails.
+func CreateIncrementalArchive(destination, source string, changes, deleted []string, followSymlinks bool, xattrs *fs.XattrFilter, format Format, encryption *Encryption) (string, *Manifest, error) {
+	return writeArchive(destination, "incremental_backup", format, encryption, func(archiver Archiver) (*Manifest, error) {
+		return writeEntries(archiver, source, changes, deleted, followSymlinks, xattrs, true)
+	})
+}
 
-		// Copy the file contents to the zip archive.
-		_, err = io.Copy(writer, src)
-		src.Close()
+// CreateDifferentialArchive creates an archive containing the files changed
+// since the base full snapshot. Paths deleted since the base are recorded as
+// tombstones in the archive's manifest.
 
-		// Check if the copy operation was successful.
-		if err != nil {
-			return "", fmt.Errorf("failed to copy file to archive: %w", err)
-		}
-	}
+// Parameters:
+// - destination: The directory where the archive file will be saved.
+// - source: The root directory of the files to be archived.
+// - changes: A list of file paths that have changed since the base snapshot.
+// - deleted: The entry names deleted since the base snapshot.
+// - followSymlinks: Whether to archive symbolic links as their target.
+// - xattrs: The filter selecting the extended attributes to archive; nil archives none.
+// - format: The container format of the archive.
+// - encryption: The encryption settings; nil or disabled writes a plaintext archive.
 
-	// Return the path to the created archive file.
-	return archivePath, nil
+// Returns:
+// - string: The path to the created archive file.
+// - *Manifest: The manifest of the entries written.
+// - error: An error if the archive creation fails.
+func CreateDifferentialArchive(destination, source string, changes, deleted []string, followSymlinks bool, xattrs *fs.XattrFilter, format Format, encryption *Encryption) (string, *Manifest, error) {
+	return writeArchive(destination, "differential_backup", format, encryption, func(archiver Archiver) (*Manifest, error) {
+		return writeEntries(archiver, source, changes, deleted, followSymlinks, xattrs, true)
+	})
 }
 
-// CreateIncrementalArchive creates a zip archive containing only the specified changed files.
+// writeArchive creates a timestamped archive in the destination directory and
+// lets write fill it, encrypting it when encryption is enabled. A partially
+// written archive is removed again.
 
 // Parameters:
 // - destination: The directory where the archive file will be saved.
-// - source: The root directory of the files to be archived.
-// - changes: A list of file paths that have changed and need to be archived.
+// - prefix: The file name prefix of the archive.
+// - format: The container format of the archive.
+// - encryption: The encryption settings; nil or disabled writes a plaintext archive.
+// - write: Writes the entries and closes the archiver, returning their manifest.
 
 // Returns:
 // - string: The path to the created archive file.
+// - *Manifest: The manifest of the entries written.
 // - error: An error if the archive creation fails.
-func CreateIncrementalArchive(destination, source string, changes []string) (string, error) {
+func writeArchive(destination, prefix string, format Format, encryption *Encryption, write func(Archiver) (*Manifest, error)) (_ string, _ *Manifest, err error) {
 	// Generate a timestamp for the archive file name.
 	timestamp := time.Now().Format("20060102150405")
-	archivePath := filepath.Join(destination, fmt.Sprintf("incremental_backup_%s.zip", timestamp))
+	archivePath := filepath.Join(destination, fmt.Sprintf("%s_%s%s", prefix, timestamp, format.Extension()))
+	encrypted := encryption != nil && encryption.Enabled
+	if encrypted {
+		archivePath += encryptedExt
+	}
 
-	// Create the archive file
-	file, err := os.Create(archivePath)
+	// Create the archive file, never replacing an existing archive.
+	file, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
-		return "", fmt.Errorf("failed to create archive file: %w", err)
+		return "", nil, fmt.Errorf("failed to create archive file: %w", err)
 	}
 	defer file.Close()
 
-	// Initialise the zip writer
-	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
-
-	// Iterate over each file that has changed.
-	for _, filePath := range changes {
-		// Get file information
-		info, err := fs.GetFileMetadata(filePath)
+	// Never leave a partial archive behind.
+	defer func() {
 		if err != nil {
-			return "", fmt.Errorf("failed to get file info: %w", err)
+			os.Remove(archivePath)
 		}
+	}()
+
+	// Route the archive through the encryption layer when enabled.
+	var w io.Writer = file
+	var encrypter io.WriteCloser
+	if encrypted {
+		if encrypter, err = encryptWriter(file, encryption); err != nil {
+			return "", nil, err
+		}
+		w = encrypter
+	}
 
-		// Create a zip header based on the file info.
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return "", fmt.Errorf("failed to get file info header: %w", err)
+	// Initialise the archiver for the requested format.
+	archiver, err := NewArchiver(w, format)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Write the entries and their manifest, and flush the archive.
+	manifest, err := write(archiver)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Seal the final encrypted chunk.
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return "", nil, fmt.Errorf("failed to finalise encryption: %w", err)
 		}
-		header.Name = filepath.Base(filePath)
+	}
+
+	// Return the path to the created archive file.
+	return archivePath, manifest, nil
+}
 
-		// Create a writer for the zip file
-		writer, err := zipWriter.CreateHeader(header)
+// writeEntries adds each path to the archiver and closes it.
+
+// Parameters:
+// - archiver: The archiver receiving the entries.
+// - source: The root directory the entry names are relative to.
+// - paths: The files and directories to archive.
+// - deleted: The entry names to record as tombstones.
+// - followSymlinks: Whether to store symbolic links as their target.
+// - xattrs: The filter selecting the extended attributes to store; nil stores none.
+// - embedManifest: Whether to store the manifest as the archive's last entry.
+
+// Returns:
+// - *Manifest: The manifest of the entries written.
+// - error: An error if any entry cannot be written or the archive cannot be finalised.
+func writeEntries(archiver Archiver, source string, paths, deleted []string, followSymlinks bool, xattrs *fs.XattrFilter, embedManifest bool) (*Manifest, error) {
+	manifest := &Manifest{Deleted: deleted}
+	links := fs.NewHardLinks()
+
+	// Iterate over each path and add it to the archive.
+	for _, path := range paths {
+		entry, err := addToArchive(archiver, source, path, followSymlinks, xattrs, links)
 		if err != nil {
-			return "", fmt.Errorf("failed to create zip header: %w", err)
+			return nil, err
 		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
 
-		// Open the source file.
-		src, err := os.Open(filePath)
-		if err != nil {
-			return "", fmt.Errorf("failed to open source file: %w", err)
+	// Embed the manifest and flush the archive.
+	if embedManifest {
+		if err := finishArchive(archiver, manifest); err != nil {
+			return nil, err
 		}
+	} else if err := archiver.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalise archive: %w", err)
+	}
 
-		// Copy the file contents to the zip archive.
-		_, err = io.Copy(writer, src)
-		src.Close()
+	return manifest, nil
+}
 
-		// Check if the copy operation was successful.
-		if err != nil {
-			return "", fmt.Errorf("failed to copy file to archive: %w", err)
-		}
+// finishArchive stores the manifest as the archive's last entry, so the
+// archive describes itself, and closes the archiver.
+
+// Parameters:
+// - archiver: The archiver of the archive being created.
+// - manifest: The manifest of the entries written.
+
+// Returns:
+// - error: An error if the manifest cannot be written or the archive cannot be finalised.
+func finishArchive(archiver Archiver, manifest *Manifest) error {
+	// Write the manifest entry.
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	entry := Entry{
+		Name:    ManifestEntryName,
+		Type:    fs.TypeFile,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := archiver.WriteEntry(entry, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
 	}
 
-	// Return the path to the created archive file.
-	return archivePath, nil
+	// Flush the archive before reporting success.
+	if err := archiver.Close(); err != nil {
+		return fmt.Errorf("failed to finalise archive: %w", err)
+	}
+
+	return nil
 }
 
-// ExtractArchive extracts the contents of a zip archive to the specified destination directory.
+// addToArchive writes a single file system entry into the archive,
+// naming it by its path relative to the source root.
 
 // Parameters:
-// - archivePath: The path to the zip archive file.
-// - destination: The path to the directory where the archive contents will be extracted.
+// - archiver: The archiver of the archive being created.
+// - source: The root directory the entry names are relative to.
+// - path: The path of the entry to add.
+// - followSymlinks: Whether to store symbolic links as their target.
+// - xattrs: The filter selecting the extended attributes to store; nil stores none.
+// - links: The hard link grouping of the archive, storing each file's contents only once.
 
 // Returns:
-// - error: An error if the extraction fails at any point.
-func ExtractArchive(archivePath, destination string) error {
-	// Open the zip archive file
-	file, err := os.Open(archivePath)
+// - Entry: The entry written, including the hash of its contents.
+// - error: An error if the entry cannot be written.
+func addToArchive(archiver Archiver, source, path string, followSymlinks bool, xattrs *fs.XattrFilter, links *fs.HardLinks) (Entry, error) {
+	// Name the entry by its slash-separated path relative to the source root.
+	name, err := fs.RelativePath(source, path)
 	if err != nil {
-		return fmt.Errorf("failed to open archive file: %w", err)
+		return Entry{}, err
 	}
-	defer file.Close()
 
-	// Retrieve file information
-	info, err := file.Stat()
+	// Describe the entry from the file information.
+	entry, err := fs.DescribeFile(path, name, followSymlinks, xattrs)
 	if err != nil {
-		return fmt.Errorf("failed to get archive file info: %w", err)
+		return Entry{}, err
+	}
+
+	// Further names of an archived file are stored as hard links to it.
+	links.Link(&entry)
+
+	// Only regular files have contents to copy.
+	if entry.Type != fs.TypeFile {
+		return entry, archiver.WriteEntry(entry, nil)
 	}
 
-	// Create a new zip reader for the file.
-	zipReader, err := zip.NewReader(file, info.Size())
+	// Open the source file.
+	src, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to create zip reader: %w", err)
+		return Entry{}, fmt.Errorf("failed to open source file: %w", err)
 	}
+	defer src.Close()
 
-	// Iterate over each file in the zip archive.
-	for _, zf := range zipReader.File {
-		// Construct the destination file path
-		dstPath := filepath.Join(destination, zf.Name)
+	// Find the holes of sparse files, which are neither read nor stored.
+	var content io.Reader = src
+	if entry.Sparse, err = fs.DataSegments(src); err != nil {
+		return Entry{}, err
+	}
+	if entry.Sparse != nil {
+		content = fs.SparseFileReader(src, entry.Sparse, entry.Size)
+	}
 
-		// Create the destination directory if it doesn't exist.
-		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
-			return fmt.Errorf("failed to create destination directory: %w", err)
-		}
+	// Copy the file contents into the archive, hashing them on the way.
+	hash := sha256.New()
+	if err := archiver.WriteEntry(entry, io.TeeReader(content, hash)); err != nil {
+		return Entry{}, err
+	}
+	entry.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	return entry, nil
+}
 
-		// Create the destination file
-		dstFile, err := os.Create(dstPath)
+// ExtractArchive extracts the contents of an archive to the specified destination directory.
+// The archive format is detected automatically and encrypted archives are decrypted.
+// Files and links get their recorded permissions, ownership and times back right
+// away. Directories are only created: writing their children changes their
+// times, so their metadata is returned to be applied with RestoreDirectories
+// once everything has been extracted. Entries are never written outside the
+// destination: absolute names, ".." components and paths leading through
+// symbolic links, whether restored earlier or already present, abort the
+// extraction with an *UnsafePathError. Entries can be restored under other
+// names; when only some entries are selected, the contents of the others are
+// never read.
+
+// Parameters:
+// - archivePath: The path to the archive file.
+// - destination: The path to the directory where the archive contents will be extracted.
+// - names: The names to restore each selected entry under, by archive name; nil extracts every entry as it is named.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - []Entry: The directory entries extracted, in archive order.
+// - error: An error if the extraction fails at any point, including tampering.
+func ExtractArchive(archivePath, destination string, names map[string]string, encryption *Encryption) ([]Entry, error) {
+	// Open the archive, detecting its format.
+	extractor, err := OpenExtractor(archivePath, encryption)
+	if err != nil {
+		return nil, err
+	}
+	defer extractor.Close()
+
+	// Iterate over each entry in the archive.
+	var dirs []Entry
+	for {
+		entry, content, err := extractor.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return fmt.Errorf("failed to create destination file: %w", err)
+			return nil, err
+		}
+
+		// Skip goback's own entries such as the embedded manifest.
+		if IsReserved(entry.Name) {
+			continue
+		}
+
+		// Skip entries that were not selected and rename the others.
+		if names != nil {
+			name, ok := names[entry.Name]
+			if !ok {
+				continue
+			}
+			if entry.Type == fs.TypeHardlink {
+				target, ok := names[entry.Linkname]
+				if !ok {
+					return nil, fmt.Errorf("failed to extract %s: hard link %s refers to %s, which is not restored", filepath.Base(archivePath), entry.Name, entry.Linkname)
+				}
+				entry.Linkname = target
+			}
+			entry.Name = name
+		}
+
+		if err := extractEntry(entry, content, destination); err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", filepath.Base(archivePath), err)
 		}
+		if entry.Type == fs.TypeDir {
+			dirs = append(dirs, *entry)
+		}
+	}
+
+	return dirs, nil
+}
+
+// CopyEntry writes the contents of one regular file stored in an archive to
+// a writer. Zip archives and repository snapshots are indexed, so only the
+// requested contents are read from them; tar archives are streamed up to it.
 
-		// Open the source file inside the zip archive.
-		srcFile, err := zf.Open()
+// Parameters:
+// - archivePath: The path to the archive file.
+// - name: The slash-separated name of the file in the archive.
+// - w: The writer receiving the contents.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - bool: True if the archive holds the file.
+// - error: An error if the archive cannot be read or the entry is not a regular file.
+func CopyEntry(archivePath, name string, w io.Writer, encryption *Encryption) (bool, error) {
+	// Open the archive, detecting its format.
+	extractor, err := OpenExtractor(archivePath, encryption)
+	if err != nil {
+		return false, err
+	}
+	defer extractor.Close()
+
+	for {
+		entry, content, err := extractor.Next()
+		if err == io.EOF {
+			return false, nil
+		}
 		if err != nil {
-			dstFile.Close()
-			return fmt.Errorf("failed to open source file: %w", err)
+			return false, err
+		}
+		if entry.Name != name {
+			continue
+		}
+
+		// Only regular files have contents.
+		if entry.Type != fs.TypeFile {
+			return true, fmt.Errorf("%s is a %s, not a regular file", name, entry.Type)
+		}
+		if _, err := io.Copy(w, content); err != nil {
+			return true, fmt.Errorf("failed to copy %s: %w", name, err)
 		}
+		return true, nil
+	}
+}
+
+// RestoreDirectories applies the recorded permissions, ownership and times to
+// extracted directories. Children are handled before their parents, so
+// restoring a read-only directory never blocks the ones below it. Directories
+// that no longer exist, for example because a later snapshot deleted them,
+// are skipped.
 
-		// Copy the contents from the source file to the destination file.
-		_, err = io.Copy(dstFile, srcFile)
-		srcFile.Close()
-		dstFile.Close()
+// Parameters:
+// - destination: The directory the archives were extracted into.
+// - dirs: The directory entries to restore, each name at most once.
 
-		// Check if the copy operation was successful.
+// Returns:
+// - error: An error if the metadata of a directory cannot be applied.
+func RestoreDirectories(destination string, dirs []Entry) error {
+	// Sort the names in reverse, which places every child before its parent.
+	sorted := append([]Entry(nil), dirs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name > sorted[j].Name
+	})
+
+	for _, entry := range sorted {
+		// Skip directories that were removed or replaced since they were
+		// extracted, including ones now reached through a symbolic link.
+		path, err := SafePath(destination, entry.Name)
 		if err != nil {
-			return fmt.Errorf("failed to copy file to destination: %w", err)
+			continue
+		}
+		if info, err := os.Lstat(path); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if err := fs.SetEntryMetadata(path, entry); err != nil {
+			return fmt.Errorf("failed to restore metadata of %s: %w", entry.Name, err)
 		}
 	}
 
 	return nil
 }
 
-// GetRecentBackup retrieves the most recent backup metadata from the specified destination directory.
+// extractEntry recreates a single archive entry below the destination directory.
 
 // Parameters:
-// - destination: The path to the directory where backups are stored.
+// - entry: The entry to recreate.
+// - content: The reader for the entry's contents.
+// - destination: The directory the archive is extracted into.
 
 // Returns:
-// - Metadata: The metadata of the most recent backup file.
-// - error: An error if no backups are found or if the traversal fails.
-func GetRecentBackup(destination string) (Metadata, error) {
-	// Traverse the specified directory to get a list of files.
-	files, err := fs.TraversalDirectory(destination)
+// - error: An error if the entry cannot be recreated.
+func extractEntry(entry *Entry, content io.Reader, destination string) error {
+	// Construct the destination path from the slash-separated entry name,
+	// making sure it stays below the destination.
+	dstPath, err := SafePath(destination, entry.Name)
 	if err != nil {
-		return Metadata{}, fmt.Errorf("failed to traverse directory: %w", err)
+		return err
 	}
 
-	// Initialise a slice to store backup metadata.
-	var backups []Metadata
+	// Recreate directory entries as directories, replacing anything else at
+	// the path so that later entries are never written through a link.
+	if entry.Type == fs.TypeDir {
+		if info, err := os.Lstat(dstPath); err == nil && !info.IsDir() {
+			os.Remove(dstPath)
+		}
+		if err := os.MkdirAll(dstPath, 0755); err != nil {
+			return fmt.Errorf("failed to create destination directory: %w", err)
+		}
+		return nil
+	}
 
-	// Iterate through the list of files.
-	for _, file := range files {
-		// Check if the file has a .zip extension
-		if filepath.Ext(file) == ".zip" {
-			// Attempt to retrieve metadata for the backup file.
-			metadata, err := GetMetadata(file)
-			if err != nil {
-				// Skip files that fail to provide metadata.
-				continue
-			}
-			// Append valid metadata to the backups slice.
-			backups = append(backups, metadata)
+	// Create the parent directory if it doesn't exist.
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	switch entry.Type {
+	case fs.TypeSymlink:
+		// Replace anything already at the path with the link.
+		os.Remove(dstPath)
+		if err := os.Symlink(entry.Linkname, dstPath); err != nil {
+			return fmt.Errorf("failed to create symlink: %w", err)
+		}
+		return fs.SetEntryMetadata(dstPath, *entry)
+	case fs.TypeHardlink:
+		// Hard link targets are archive paths that were extracted earlier,
+		// so they must stay below the destination too.
+		target, err := SafePath(destination, entry.Linkname)
+		if err != nil {
+			return fmt.Errorf("hard link %s: %w", entry.Name, err)
+		}
+		os.Remove(dstPath)
+		if err := os.Link(target, dstPath); err != nil {
+			return fmt.Errorf("failed to create hard link: %w", err)
+		}
+		return nil
+	case fs.TypeCharDevice, fs.TypeBlockDevice, fs.TypeFIFO:
+		// Only root may create device nodes, so other users skip them.
+		if entry.Type != fs.TypeFIFO && !fs.CanRestoreOwnership() {
+			return nil
 		}
+		os.Remove(dstPath)
+		if err := fs.CreateSpecialFile(dstPath, *entry); err != nil {
+			return err
+		}
+		return fs.SetEntryMetadata(dstPath, *entry)
+	case fs.TypeFile:
+	default:
+		return fmt.Errorf("cannot restore %s entry %s", entry.Type, entry.Name)
 	}
 
-	// Sort the backups slice based on the timestamp, newest last.
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].Time.Before(backups[j].Time)
-	})
+	// Replace rather than truncate an existing file, which may be hard linked
+	// to other names that must keep their contents.
+	os.Remove(dstPath)
+
+	// Create the destination file, without following a link at the path.
+	dstFile, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+
+	// Copy the contents from the archive to the destination file, leaving
+	// the holes of sparse files unallocated.
+	if entry.Sparse != nil {
+		err = fs.WriteSparse(dstFile, content, entry.Sparse, entry.Size)
+	} else {
+		_, err = io.Copy(dstFile, content)
+	}
+	dstFile.Close()
+
+	// Check if the copy operation was successful.
+	if err != nil {
+		return fmt.Errorf("failed to copy file to destination: %w", err)
+	}
+
+	// Restore the recorded permissions, ownership and times.
+	return fs.SetEntryMetadata(dstPath, *entry)
+}
+
+// RemoveDeleted removes the entries recorded as deleted by an incremental or
+// differential snapshot from a restored tree.
+
+// Parameters:
+// - destination: The directory the snapshot chain is being restored into.
+// - deleted: The slash-separated names of the deleted entries.
+
+// Returns:
+// - error: An error if a name is unsafe or an entry cannot be removed.
+func RemoveDeleted(destination string, deleted []string) error {
+	for _, name := range deleted {
+		// Never remove anything outside the restored tree.
+		path, err := SafePath(destination, name)
+		if err != nil {
+			return fmt.Errorf("refusing to delete: %w", err)
+		}
 
-	// Check if there are any backups found.
-	if len(backups) == 0 {
-		return Metadata{}, fmt.Errorf("no backups found")
+		// Remove the entry, including any contents of a deleted directory.
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove deleted entry %s: %w", name, err)
+		}
 	}
 
-	// Return the most recent backup metadata.
-	return backups[len(backups)-1], nil
+	return nil
+}
+
+// GetRecentBackup retrieves the most recent backup metadata from the specified destination directory.
+
+// Parameters:
+// - destination: The path to the directory where backups are stored.
+
+// Returns:
+// - Metadata: The metadata of the most recent backup.
+// - error: An error if no backups are found or if the catalog cannot be read.
+func GetRecentBackup(destination string) (Metadata, error) {
+	return GetRecentMetadata(destination, "")
 }
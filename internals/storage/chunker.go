@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+)
+
+// Content-defined chunking parameters. Chunk boundaries depend only on the
+// bytes around them, so an insertion early in a file only changes the
+// chunks near the insertion instead of shifting every following chunk.
+const (
+	// minChunkSize is the smallest chunk produced, except for the final one.
+	minChunkSize = 256 << 10
+	// maxChunkSize is the largest chunk produced.
+	maxChunkSize = 4 << 20
+	// avgChunkBits sets the average chunk size to roughly 2^avgChunkBits bytes.
+	avgChunkBits = 20
+)
+
+// chunkMask selects the high bits of the rolling hash that must be zero at a boundary.
+const chunkMask = uint64(1<<avgChunkBits-1) << (64 - avgChunkBits)
+
+// gearTable maps each byte value to a pseudo-random 64-bit number for the gear hash.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+
+	// Fill the table from a fixed splitmix64 sequence so boundaries are stable across runs.
+	state := uint64(0x676f6261636b) // "goback"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks using a gear rolling hash.
+type chunker struct {
+	reader *bufio.Reader
+	buf    []byte
+}
+
+// newChunker creates a chunker reading from r.
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		reader: bufio.NewReaderSize(r, 1<<20),
+		buf:    make([]byte, 0, maxChunkSize),
+	}
+}
+
+// Next returns the next chunk of the stream, or io.EOF once the stream is exhausted.
+// The returned slice is only valid until the following call.
+func (c *chunker) Next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+
+	for {
+		// Read the next byte of the stream.
+		b, err := c.reader.ReadByte()
+		if err == io.EOF {
+			if len(c.buf) == 0 {
+				return nil, io.EOF
+			}
+			return c.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+
+		// Roll the hash and cut once a boundary is found past the minimum size.
+		hash = (hash << 1) + gearTable[b]
+		if (len(c.buf) >= minChunkSize && hash&chunkMask == 0) || len(c.buf) >= maxChunkSize {
+			return c.buf, nil
+		}
+	}
+}
@@ -1,90 +1,87 @@
 package storage
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
-	"sort"
 	"time"
-
-	"github.com/ppriyankuu/goback/internals/fs"
 )
 
 // CleanupOldBackups removes old backup files exceeding the specified retention period.
+// Expired snapshots that a kept incremental snapshot still builds on are kept
+// until their whole chain has expired.
 
 // Parameters:
 // - destination: The directory containing the backup files.
-// - retentionDays: The number of days to retain backups before deletion.
+// - retentionDays: The number of days to retain backups before deletion; zero or less keeps all backups.
 
 // Returns:
-// - error: An error if directory traversal or file removal fails.
+// - error: An error if the catalog cannot be read or file removal fails.
 func CleanupOldBackups(destination string, retentionDays int) error {
-	// Traverse the destination directory to get all files.
-	files, err := fs.TraversalDirectory(destination)
-	if err != nil {
-		return fmt.Errorf("failed to traverse directory: %w", err)
+	// Without a retention period there is nothing to clean up.
+	if retentionDays <= 0 {
+		return nil
 	}
 
-	// Collect metdata of all backup files.
-	var backups []Metadata
-
-	for _, file := range files {
-		// Check if the file has a .zip extension
-		if filepath.Ext(file) == ".zip" {
-			metadata, err := GetMetadata(file)
-			if err != nil {
-				continue // Skip files with invalid metadata
-			}
-			backups = append(backups, metadata)
-		}
+	// Read the catalog, sorted oldest to newest.
+	backups, err := ListMetadata(destination)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
 	}
 
-	// Sort backups by time, oldest to newest.
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].Time.Before(backups[j].Time)
-	})
-
 	// Get the current time for comparison.
 	now := time.Now()
+	expired := func(backup Metadata) bool {
+		return now.Sub(backup.StartTime).Hours() > float64(retentionDays)*24
+	}
+
+	// Keep every ancestor of a snapshot that is kept.
+	byID := make(map[string]Metadata, len(backups))
+	for _, backup := range backups {
+		byID[backup.ID] = backup
+	}
+	needed := make(map[string]bool)
+	for _, backup := range backups {
+		if expired(backup) {
+			continue
+		}
+		for parent, ok := byID[backup.Parent]; ok && !needed[parent.ID]; parent, ok = byID[parent.Parent] {
+			needed[parent.ID] = true
+		}
+	}
 
 	// Loop through backups and remove old ones.
 	for _, backup := range backups {
-		if now.Sub(backup.Time).Hours() > float64(retentionDays)*24 {
-			if err := os.Remove(backup.Path); err != nil {
-				return fmt.Errorf("failed to remove old backup: %w", err)
+		if expired(backup) && !needed[backup.ID] {
+			if err := RemoveSnapshot(backup); err != nil {
+				return err
 			}
 		}
 	}
 
+	// Release repository chunks that only removed snapshots referenced.
+	if err := PruneRepository(destination); err != nil {
+		return fmt.Errorf("failed to prune repository: %w", err)
+	}
+
 	return nil
 }
 
-// GetMetadata retrieves metadata from a corresponding JSON file.
+// RemoveSnapshot deletes the archive and manifest of a snapshot and then its catalog record.
 
 // Parameters:
-// - archivePath: The path to the archive file.
+// - metadata: The snapshot to remove.
 
 // Returns:
-// - Metadata: The metadata information extracted from the JSON file.
-// - error: An error if reading or unmarshalling fails.
-func GetMetadata(archivePath string) (Metadata, error) {
-	// Construct the metadata file path.
-	metadataPath := filepath.Join(filepath.Dir(archivePath), "metadata.json")
-
-	// Read the metadata file
-	data, err := os.ReadFile(metadataPath)
-	if err != nil {
-		return Metadata{}, fmt.Errorf("failed to read metadata file: %w", err)
+// - error: An error if the archive or the record cannot be removed.
+func RemoveSnapshot(metadata Metadata) error {
+	// Remove the archive first, so a failure never leaves an archive without a record.
+	if err := os.Remove(metadata.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old backup: %w", err)
 	}
 
-	var metadata Metadata
-
-	// Unmarshal the JSON data into the Metadata struct.
-	if err := json.Unmarshal(data, &metadata); err != nil {
-		return Metadata{}, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	// Then its manifest, and finally forget the snapshot.
+	if err := RemoveManifest(metadata.Destination, metadata.ID); err != nil {
+		return err
 	}
-
-	// Return the extracted metadata.
-	return metadata, nil
+	return RemoveMetadata(metadata)
 }
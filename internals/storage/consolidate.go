@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// ConsolidateChain merges the archives of a snapshot chain into a new full
+// archive without touching the source or extracting anything to disk. Each
+// entry of the final state is streamed from the newest archive of the chain
+// that wrote it straight into the new archive, so deleted entries and
+// superseded versions are dropped along the way.
+
+// Parameters:
+// - destination: The directory where the archive file will be saved.
+// - chain: The snapshots to merge, from the full snapshot to the last one applied.
+// - state: The manifest of the whole tree as of the last snapshot of the chain.
+// - format: The container format of the new archive.
+// - encryption: The encryption settings used to read the chain and write the new archive.
+
+// Returns:
+// - string: The path to the created archive file.
+// - *Manifest: The manifest of the entries written.
+// - error: An error if the chain cannot be read, an entry is missing or damaged, or writing fails.
+func ConsolidateChain(destination string, chain []Metadata, state *Manifest, format Format, encryption *Encryption) (string, *Manifest, error) {
+	// Work out which archive of the chain holds the final version of each entry.
+	// Later archives supersede earlier ones; the full archive holds the rest.
+	owners := make(map[string]int)
+	for i := 1; i < len(chain); i++ {
+		written, err := ReadArchiveManifest(chain[i].Path, encryption)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read manifest of snapshot %s: %w", chain[i].ID, err)
+		}
+		for _, entry := range written.Entries {
+			owners[entry.Name] = i
+		}
+	}
+
+	// Index the entries of the final state by name.
+	wanted := make(map[string]Entry, len(state.Entries))
+	for _, entry := range state.Entries {
+		wanted[entry.Name] = entry
+	}
+
+	return writeArchive(destination, "consolidated_backup", format, encryption, func(archiver Archiver) (*Manifest, error) {
+		manifest := &Manifest{}
+
+		// Stream the owned entries of every archive, oldest first.
+		for i, snapshot := range chain {
+			if err := copyOwnedEntries(archiver, snapshot, i, owners, wanted, manifest, encryption); err != nil {
+				return nil, err
+			}
+		}
+
+		// Every entry of the final state must have been found in the chain.
+		if len(manifest.Entries) != len(wanted) {
+			copied := make(map[string]bool, len(manifest.Entries))
+			for _, entry := range manifest.Entries {
+				copied[entry.Name] = true
+			}
+			for _, entry := range state.Entries {
+				if !copied[entry.Name] {
+					return nil, fmt.Errorf("entry %s is missing from the snapshot chain", entry.Name)
+				}
+			}
+		}
+
+		// Embed the manifest and flush the archive.
+		if err := finishArchive(archiver, manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	})
+}
+
+// copyOwnedEntries streams the entries of one archive of a chain that hold
+// their final version into the new archive, checking their contents against
+// the final state on the way.
+
+// Parameters:
+// - archiver: The archiver of the new archive.
+// - snapshot: The snapshot whose archive is read.
+// - index: The position of the snapshot in the chain.
+// - owners: The position of the archive holding the final version of each entry; absent means the full archive.
+// - wanted: The entries of the final state by name.
+// - manifest: The manifest of the new archive, extended with every entry copied.
+// - encryption: The encryption settings used to read the archive.
+
+// Returns:
+// - error: An error if the archive cannot be read or an entry does not match the final state.
+func copyOwnedEntries(archiver Archiver, snapshot Metadata, index int, owners map[string]int, wanted map[string]Entry, manifest *Manifest, encryption *Encryption) error {
+	// Open the archive, detecting its format.
+	extractor, err := OpenExtractor(snapshot.Path, encryption)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+
+	for {
+		entry, content, err := extractor.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot %s: %w", snapshot.ID, err)
+		}
+
+		// Skip entries that were deleted or superseded by a later archive.
+		final, ok := wanted[entry.Name]
+		if !ok || owners[entry.Name] != index {
+			continue
+		}
+
+		// The final state describes the entry best, including what archive
+		// formats cannot store such as change times and inodes.
+		if final.Type != entry.Type {
+			return fmt.Errorf("entry %s in snapshot %s is a %s, expected a %s", entry.Name, snapshot.ID, entry.Type, final.Type)
+		}
+
+		// Only regular files have contents to copy.
+		if final.Type != fs.TypeFile {
+			if err := archiver.WriteEntry(final, nil); err != nil {
+				return err
+			}
+			manifest.Entries = append(manifest.Entries, final)
+			continue
+		}
+
+		// Copy the contents into the new archive, hashing them on the way.
+		hash := sha256.New()
+		if err := archiver.WriteEntry(final, io.TeeReader(content, hash)); err != nil {
+			return err
+		}
+		sum := hex.EncodeToString(hash.Sum(nil))
+		if final.Hash != "" && final.Hash != sum {
+			return fmt.Errorf("entry %s in snapshot %s does not match its manifest", entry.Name, snapshot.ID)
+		}
+		final.Hash = sum
+		manifest.Entries = append(manifest.Entries, final)
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptedExt is appended to the name of encrypted archives.
+const encryptedExt = ".age"
+
+// ageMagic is the first line of every age-encrypted file.
+var ageMagic = []byte("age-encryption.org/v1\n")
+
+// scryptStanza marks archives whose file key is wrapped by a passphrase.
+var scryptStanza = []byte("\n-> scrypt ")
+
+// Encryption configures client-side encryption of archives. Archives are
+// encrypted with age: a random file key is wrapped either by a scrypt-derived
+// passphrase key or for each X25519 recipient, and the contents are sealed in
+// authenticated ChaCha20-Poly1305 chunks, so any modification or truncation
+// is detected on decryption.
+type Encryption struct {
+	// Enabled encrypts newly written archives.
+	Enabled bool
+	// Passphrase returns the passphrase. It is only called when a
+	// passphrase is actually needed.
+	Passphrase func() (string, error)
+	// Recipients are X25519 public keys ("age1..."). When set, new archives
+	// are encrypted to them instead of the passphrase, so only holders of the
+	// matching private keys can read them.
+	Recipients []string
+	// IdentityFiles are files holding the private keys used to decrypt
+	// archives encrypted to recipients.
+	IdentityFiles []string
+}
+
+// UsesRecipients reports whether new archives are encrypted to public keys,
+// in which case the writer itself cannot read them back.
+func (e *Encryption) UsesRecipients() bool {
+	return e != nil && e.Enabled && len(e.Recipients) > 0
+}
+
+// Validate checks that the recipients are valid public keys and that the
+// identity files can be read, without obtaining the passphrase.
+
+// Returns:
+// - error: An error describing the first invalid setting.
+func (e *Encryption) Validate() error {
+	if e == nil {
+		return nil
+	}
+
+	// Every recipient must be an X25519 public key.
+	for _, key := range e.Recipients {
+		if _, err := age.ParseX25519Recipient(key); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", key, err)
+		}
+	}
+
+	// Every identity file must hold at least one private key.
+	for _, path := range e.IdentityFiles {
+		if _, err := ReadIdentities(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// passphrase resolves the configured passphrase.
+func (e *Encryption) passphrase() (string, error) {
+	if e == nil || e.Passphrase == nil {
+		return "", errors.New("archive is encrypted but no passphrase is configured")
+	}
+
+	// Resolve the passphrase and refuse empty ones.
+	passphrase, err := e.Passphrase()
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain passphrase: %w", err)
+	}
+	if passphrase == "" {
+		return "", errors.New("passphrase is empty")
+	}
+
+	return passphrase, nil
+}
+
+// encryptWriter wraps w so that everything written is encrypted with the passphrase.
+// The returned writer must be closed to flush the final authenticated chunk.
+
+// Parameters:
+// - w: The writer receiving the encrypted bytes.
+// - encryption: The encryption settings providing the recipients or passphrase.
+
+// Returns:
+// - io.WriteCloser: The writer accepting plaintext.
+// - error: An error if a key cannot be obtained or the header cannot be written.
+func encryptWriter(w io.Writer, encryption *Encryption) (io.WriteCloser, error) {
+	var recipients []age.Recipient
+	if encryption.UsesRecipients() {
+		// Encrypt to every configured public key.
+		for _, key := range encryption.Recipients {
+			recipient, err := age.ParseX25519Recipient(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %w", key, err)
+			}
+			recipients = append(recipients, recipient)
+		}
+	} else {
+		// Obtain the passphrase.
+		passphrase, err := encryption.passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		// Derive the recipient from the passphrase.
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	// Start the encrypted stream.
+	writer, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encryption: %w", err)
+	}
+
+	return writer, nil
+}
+
+// decryptReader wraps r so that reads return the decrypted plaintext. Reads fail
+// if the ciphertext has been modified or truncated. Passphrase-encrypted archives
+// are opened with the passphrase, all others with the configured identity files.
+
+// Parameters:
+// - r: The buffered reader providing the encrypted bytes.
+// - encryption: The encryption settings providing the passphrase or identities.
+
+// Returns:
+// - io.Reader: The reader returning plaintext.
+// - error: An error if the key is wrong or the header is invalid.
+func decryptReader(r *bufio.Reader, encryption *Encryption) (io.Reader, error) {
+	// Pick the identities matching how the archive was encrypted.
+	identities, err := encryption.identities(usesPassphrase(r))
+	if err != nil {
+		return nil, err
+	}
+
+	// Unwrap the file key and start decrypting.
+	reader, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive (wrong key or tampered archive): %w", err)
+	}
+
+	return reader, nil
+}
+
+// decryptReaderAt gives random access to the plaintext of an encrypted file.
+// age seals the payload in independently authenticated chunks, so each chunk
+// is decrypted and verified in memory when it is first read and nothing is
+// ever written out in plaintext. Zip archives, which are read from their end,
+// are opened this way.
+
+// Parameters:
+// - file: The encrypted file.
+// - encryption: The encryption settings providing the passphrase or identities.
+
+// Returns:
+// - io.ReaderAt: The plaintext, decrypted as it is read.
+// - int64: The size of the plaintext.
+// - error: An error if the key is wrong, the header is invalid or the file is truncated.
+func decryptReaderAt(file *os.File, encryption *Encryption) (io.ReaderAt, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get archive file info: %w", err)
+	}
+
+	// Pick the identities matching how the archive was encrypted.
+	header := bufio.NewReader(io.NewSectionReader(file, 0, info.Size()))
+	identities, err := encryption.identities(usesPassphrase(header))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Unwrap the file key; the last chunk is authenticated right away.
+	plaintext, size, err := age.DecryptReaderAt(file, info.Size(), identities...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decrypt archive (wrong key or tampered archive): %w", err)
+	}
+
+	return plaintext, size, nil
+}
+
+// identities returns the identities able to decrypt an archive, derived from
+// the passphrase or loaded from the identity files.
+func (e *Encryption) identities(passphrase bool) ([]age.Identity, error) {
+	if passphrase {
+		// Obtain the passphrase.
+		passphrase, err := e.passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		// Derive the identity from the passphrase.
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive decryption key: %w", err)
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	// Load the private keys from every identity file.
+	if e == nil || len(e.IdentityFiles) == 0 {
+		return nil, errors.New("archive is encrypted to recipients but no identity file is configured")
+	}
+	var identities []age.Identity
+	for _, path := range e.IdentityFiles {
+		loaded, err := ReadIdentities(path)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, loaded...)
+	}
+
+	return identities, nil
+}
+
+// usesPassphrase reports whether the buffered age header wraps the file key with a passphrase.
+func usesPassphrase(r *bufio.Reader) bool {
+	// The header is small, so a short peek covers its stanzas.
+	header, _ := r.Peek(256)
+	return bytes.Contains(header, scryptStanza)
+}
+
+// isEncrypted reports whether the buffered stream starts with an age header.
+func isEncrypted(r *bufio.Reader) bool {
+	header, _ := r.Peek(len(ageMagic))
+	return bytes.Equal(header, ageMagic)
+}
+
+// trimEncryptedExt removes the encrypted archive suffix from a path, if present.
+func trimEncryptedExt(path string) string {
+	return strings.TrimSuffix(path, encryptedExt)
+}
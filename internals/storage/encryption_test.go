@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// ageChunkSize is the plaintext size of the chunks age seals payloads in.
+const ageChunkSize = 64 * 1024
+
+// testEncryption encrypts to a freshly generated key pair, which unlike a
+// passphrase needs no slow key derivation.
+func testEncryption(t *testing.T) *Encryption {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &Encryption{Enabled: true, Recipients: []string{identity.Recipient().String()}, IdentityFiles: []string{identityFile}}
+}
+
+// encryptTestFile encrypts data into a file with age.Encrypt.
+func encryptTestFile(t *testing.T, encryption *Encryption, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.age")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := encryptWriter(file, encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// openDecrypted opens an encrypted file for random access.
+func openDecrypted(t *testing.T, path string, encryption *Encryption) (io.ReaderAt, int64, error) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return decryptReaderAt(file, encryption)
+}
+
+func TestDecryptReaderAt(t *testing.T) {
+	encryption := testEncryption(t)
+	random := rand.New(rand.NewSource(1))
+
+	// Empty, around chunk boundaries and exact multiples of the chunk size.
+	for _, size := range []int{0, 1, ageChunkSize - 1, ageChunkSize, ageChunkSize + 1, 2 * ageChunkSize, 3*ageChunkSize + 123} {
+		data := make([]byte, size)
+		random.Read(data)
+		plaintext, n, err := openDecrypted(t, encryptTestFile(t, encryption, data), encryption)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if n != int64(size) {
+			t.Fatalf("size %d: plaintext size %d", size, n)
+		}
+
+		// Read the whole plaintext sequentially.
+		got, err := io.ReadAll(io.NewSectionReader(plaintext, 0, n))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size %d: sequential read differs, err %v", size, err)
+		}
+
+		// And ranges crossing chunk boundaries in random order.
+		for i := 0; i < 50 && size > 0; i++ {
+			off := random.Intn(size)
+			buf := make([]byte, random.Intn(2*ageChunkSize)+1)
+			read, err := plaintext.ReadAt(buf, int64(off))
+			want := data[off:min(off+len(buf), size)]
+			if !bytes.Equal(buf[:read], want) || (read < len(buf) && err != io.EOF) {
+				t.Fatalf("size %d: ReadAt(%d, %d) = %d, %v", size, len(buf), off, read, err)
+			}
+		}
+	}
+}
+
+func TestDecryptReaderAtRejectsTampering(t *testing.T) {
+	encryption := testEncryption(t)
+	// Two full chunks, so dropping the last one leaves a valid-looking file.
+	data := bytes.Repeat([]byte("goback!!"), 2*ageChunkSize/8)
+	sealed, err := os.ReadFile(encryptTestFile(t, encryption, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"flipped byte", func(b []byte) []byte { b[len(b)-ageChunkSize] ^= 1; return b }},
+		{"truncated at a chunk", func(b []byte) []byte { return b[:len(b)-(ageChunkSize+16)] }},
+		{"truncated in a chunk", func(b []byte) []byte { return b[:len(b)-100] }},
+		{"trailing data", func(b []byte) []byte { return append(b, make([]byte, 100)...) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive.age")
+			if err := os.WriteFile(path, tt.modify(bytes.Clone(sealed)), 0600); err != nil {
+				t.Fatal(err)
+			}
+			plaintext, n, err := openDecrypted(t, path, encryption)
+			if err != nil {
+				return
+			}
+			if _, err := io.ReadAll(io.NewSectionReader(plaintext, 0, n)); err == nil {
+				t.Error("tampered archive decrypted without an error")
+			}
+		})
+	}
+}
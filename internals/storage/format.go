@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// Format identifies the container format of a backup archive.
+type Format string
+
+const (
+	// FormatZip stores entries in a deflate-compressed zip archive.
+	FormatZip Format = "zip"
+	// FormatTar stores entries in an uncompressed tar archive.
+	FormatTar Format = "tar"
+	// FormatTarGz stores entries in a gzip-compressed tar archive.
+	FormatTarGz Format = "tar.gz"
+	// FormatTarZst stores entries in a zstd-compressed tar archive.
+	FormatTarZst Format = "tar.zst"
+)
+
+// DefaultFormat is the archive format used when none is configured.
+const DefaultFormat = FormatZip
+
+// formats lists every supported format, used for lookups by extension.
+var formats = []Format{FormatZip, FormatTar, FormatTarGz, FormatTarZst}
+
+// Extension returns the file name extension used for archives of the format.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ParseFormat converts a user supplied format name into a Format.
+
+// Parameters:
+// - name: The format name, e.g. "zip", "tar", "tar.gz" or "tar.zst".
+
+// Returns:
+// - Format: The matching format, or DefaultFormat when the name is empty.
+// - error: An error if the name is not a known format.
+func ParseFormat(name string) (Format, error) {
+	// Normalise the name and accept a few common aliases.
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "":
+		return DefaultFormat, nil
+	case "zip":
+		return FormatZip, nil
+	case "tar":
+		return FormatTar, nil
+	case "tar.gz", "tgz", "gzip", "gz":
+		return FormatTarGz, nil
+	case "tar.zst", "tzst", "zstd", "zst":
+		return FormatTarZst, nil
+	}
+
+	return "", fmt.Errorf("unknown archive format %q", name)
+}
+
+// IsArchive reports whether the file name carries the extension of a supported archive format.
+
+// Parameters:
+// - path: The file path to check.
+
+// Returns:
+// - bool: True if the path looks like a backup archive.
+func IsArchive(path string) bool {
+	// Repository snapshots are restored just like archives.
+	if IsSnapshotTree(path) {
+		return true
+	}
+
+	// Encrypted archives carry an additional suffix.
+	path = trimEncryptedExt(path)
+	for _, format := range formats {
+		if strings.HasSuffix(path, format.Extension()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Magic numbers used to recognise archive formats regardless of file name.
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+)
+
+// DetectFormat determines the format of an archive by inspecting its leading bytes.
+// Encrypted archives are decrypted to inspect the archive inside.
+
+// Parameters:
+// - archivePath: The path to the archive file.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - Format: The detected archive format.
+// - error: An error if the file cannot be read or the format is not recognised.
+func DetectFormat(archivePath string, encryption *Encryption) (Format, error) {
+	// Open the archive file.
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer file.Close()
+
+	// Look through the encryption layer, if any.
+	reader, _, err := plaintextReader(file, encryption)
+	if err != nil {
+		return "", err
+	}
+
+	return detectFormat(reader, archivePath)
+}
+
+// plaintextReader returns a buffered reader over the plaintext of an archive,
+// transparently decrypting it when it is encrypted.
+
+// Parameters:
+// - r: The reader over the archive file.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - *bufio.Reader: The buffered plaintext reader.
+// - bool: True if the archive is encrypted.
+// - error: An error if the archive cannot be decrypted.
+func plaintextReader(r io.Reader, encryption *Encryption) (*bufio.Reader, bool, error) {
+	buffered := bufio.NewReader(r)
+	if !isEncrypted(buffered) {
+		return buffered, false, nil
+	}
+
+	// Decrypt the archive.
+	decrypted, err := decryptReader(buffered, encryption)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return bufio.NewReader(decrypted), true, nil
+}
+
+// detectFormat matches the leading bytes of a buffered plaintext stream
+// against the magic numbers of the known formats without consuming them.
+
+// Parameters:
+// - r: The buffered plaintext reader.
+// - name: The archive name used in error messages.
+
+// Returns:
+// - Format: The detected archive format.
+// - error: An error if the header cannot be read or the format is not recognised.
+func detectFormat(r *bufio.Reader, name string) (Format, error) {
+	// Peek enough of the header to cover the tar magic at offset 257.
+	header, err := r.Peek(512)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	// Match the header against the known magic numbers.
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, zipEmptyMagic):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return FormatTarZst, nil
+	case len(header) >= 262 && bytes.Equal(header[257:262], tarMagic):
+		return FormatTar, nil
+	case len(header) == 512 && bytes.Count(header, []byte{0}) == 512:
+		// An empty tar archive consists solely of zero blocks.
+		return FormatTar, nil
+	}
+
+	return "", fmt.Errorf("unrecognised archive format: %s", name)
+}
+
+// Entry describes a single file system entry stored in an archive.
+type Entry = fs.FileEntry
+
+// Archiver writes entries into an archive container.
+type Archiver interface {
+	// WriteEntry adds an entry to the archive, reading its contents
+	// from content for regular files. Only the data segments of sparse
+	// files are stored.
+	WriteEntry(entry Entry, content io.Reader) error
+	// Close flushes the archive. It does not close the underlying writer.
+	Close() error
+}
+
+// Extractor reads entries back out of an archive container in order.
+type Extractor interface {
+	// Next advances to the next entry and returns it together with a
+	// reader for its contents, valid until the following call. Sparse
+	// files read in full, with zeros for their holes. It returns io.EOF
+	// once all entries have been read.
+	Next() (*Entry, io.Reader, error)
+	// Close releases the resources held by the extractor.
+	Close() error
+}
+
+// NewArchiver creates an Archiver that writes the given format to w.
+
+// Parameters:
+// - w: The writer receiving the archive bytes.
+// - format: The archive format to produce.
+
+// Returns:
+// - Archiver: The archiver for the format.
+// - error: An error if the format is not supported.
+func NewArchiver(w io.Writer, format Format) (Archiver, error) {
+	switch format {
+	case FormatZip:
+		return newZipArchiver(w), nil
+	case FormatTar, FormatTarGz, FormatTarZst:
+		return newTarArchiver(w, format)
+	}
+
+	return nil, fmt.Errorf("unsupported archive format %q", format)
+}
+
+// OpenExtractor opens an archive for reading, detecting its format automatically
+// and decrypting it if it is encrypted.
+
+// Parameters:
+// - archivePath: The path to the archive file.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - Extractor: The extractor reading the archive's entries.
+// - error: An error if the archive cannot be opened or its format is unknown.
+func OpenExtractor(archivePath string, encryption *Encryption) (Extractor, error) {
+	// Repository snapshots are read from the chunk store instead of a container.
+	if IsSnapshotTree(archivePath) {
+		return openRepositoryExtractor(archivePath)
+	}
+
+	// Open the archive file.
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+
+	extractor, err := newExtractor(file, archivePath, encryption)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return extractor, nil
+}
+
+// newExtractor creates the extractor for the format found in the archive file.
+func newExtractor(file *os.File, archivePath string, encryption *Encryption) (Extractor, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive file info: %w", err)
+	}
+
+	// Look through the encryption layer, if any. Encrypted archives are
+	// decrypted chunk by chunk as they are read, so no plaintext copy of
+	// them is ever written to disk.
+	var plaintext io.ReaderAt = file
+	size := info.Size()
+	reader := bufio.NewReader(file)
+	if isEncrypted(reader) {
+		if plaintext, size, err = decryptReaderAt(file, encryption); err != nil {
+			return nil, err
+		}
+		reader = bufio.NewReader(io.NewSectionReader(plaintext, 0, size))
+	}
+
+	// Detect the format from the archive contents.
+	format, err := detectFormat(reader, archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tar archives are read as a stream, zip archives need random access.
+	if format != FormatZip {
+		return newTarExtractor(reader, format, file)
+	}
+	return newZipExtractor(plaintext, size, file)
+}
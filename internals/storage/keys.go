@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"filippo.io/age"
+)
+
+// GenerateIdentity creates a new X25519 key pair and writes the private key to
+// a file readable only by its owner. The public key is returned for use as a
+// recipient in the configuration.
+
+// Parameters:
+// - path: The file to write the private key to. It must not already exist.
+
+// Returns:
+// - string: The public key ("age1...").
+// - error: An error if the key cannot be generated or written.
+func GenerateIdentity(path string) (string, error) {
+	// Generate the key pair.
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	recipient := identity.Recipient().String()
+
+	// Refuse to overwrite an existing key file.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer file.Close()
+
+	// Write the key in the same layout as age-keygen.
+	_, err = fmt.Fprintf(file, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), recipient, identity.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return recipient, nil
+}
+
+// ReadIdentities reads the private keys stored in an identity file.
+
+// Parameters:
+// - path: The identity file.
+
+// Returns:
+// - []age.Identity: The identities found in the file.
+// - error: An error if the file cannot be read or contains no valid keys.
+func ReadIdentities(path string) ([]age.Identity, error) {
+	// Open the identity file.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer file.Close()
+
+	// Parse every key in the file.
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file %s: %w", path, err)
+	}
+
+	return identities, nil
+}
+
+// InspectIdentity returns the public keys matching the private keys of an identity file.
+
+// Parameters:
+// - path: The identity file.
+
+// Returns:
+// - []string: The public key of each X25519 identity in the file.
+// - error: An error if the file cannot be read.
+func InspectIdentity(path string) ([]string, error) {
+	// Read the identities.
+	identities, err := ReadIdentities(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the public key of each one.
+	var recipients []string
+	for _, identity := range identities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			recipients = append(recipients, x25519.Recipient().String())
+		}
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// manifestsDir is the directory below the destination holding one manifest per snapshot.
+const manifestsDir = "manifests"
+
+// ManifestEntryName is the name of the manifest stored as the last entry of every
+// archive. Entries below ".goback/" are reserved and never restored.
+const ManifestEntryName = ".goback/manifest.json"
+
+// ReservedDir is the top-level directory of archive entries used by goback
+// itself. Backups refuse sources holding a directory of that name.
+const ReservedDir = ".goback"
+
+// reservedPrefix marks archive entries used by goback itself.
+const reservedPrefix = ReservedDir + "/"
+
+// Manifest lists every entry of a snapshot with its metadata and content hash.
+// Incremental and differential snapshots also list the entries deleted since
+// their parent or base.
+type Manifest struct {
+	Entries []fs.FileEntry `json:"entries"`
+	Deleted []string       `json:"deleted,omitempty"`
+}
+
+// FileCount returns the number of entries in the manifest.
+func (m *Manifest) FileCount() int {
+	return len(m.Entries)
+}
+
+// Bytes returns the total size of the regular files in the manifest.
+func (m *Manifest) Bytes() int64 {
+	var total int64
+	for _, entry := range m.Entries {
+		total += entry.Size
+	}
+	return total
+}
+
+// IsReserved reports whether an archive entry name belongs to goback's own data.
+
+// Parameters:
+// - name: The slash-separated entry name.
+
+// Returns:
+// - bool: True for reserved entries such as the embedded manifest.
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, reservedPrefix)
+}
+
+// StoreManifest writes the manifest of a snapshot next to the catalog, gzip
+// compressed and, when the snapshot is encrypted, encrypted the same way.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - id: The snapshot ID.
+// - manifest: The manifest to store.
+// - encryption: The encryption settings; nil or disabled stores it in plaintext.
+
+// Returns:
+// - error: An error if the manifest cannot be written.
+func StoreManifest(destination, id string, manifest *Manifest, encryption *Encryption) error {
+	// Make sure the manifests directory exists.
+	dir := filepath.Join(destination, manifestsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create manifests directory: %w", err)
+	}
+
+	// Create the manifest file.
+	path := filepath.Join(dir, id+".json.gz")
+	encrypted := encryption != nil && encryption.Enabled
+	if encrypted {
+		path += encryptedExt
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest file: %w", err)
+	}
+	defer file.Close()
+
+	// Route the manifest through the encryption layer when enabled.
+	var w io.Writer = file
+	var encrypter io.WriteCloser
+	if encrypted {
+		if encrypter, err = encryptWriter(file, encryption); err != nil {
+			return err
+		}
+		w = encrypter
+	}
+
+	// Write the gzip-compressed JSON.
+	compressor := gzip.NewWriter(w)
+	if err := json.NewEncoder(compressor).Encode(manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return fmt.Errorf("failed to finalise encryption: %w", err)
+		}
+	}
+
+	return file.Sync()
+}
+
+// ReadManifest reads the manifest of a snapshot stored next to the catalog.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - id: The snapshot ID.
+// - encryption: The encryption settings, used only if the manifest is encrypted.
+
+// Returns:
+// - *Manifest: The manifest of the snapshot.
+// - error: An error if the manifest is missing, cannot be decrypted or is invalid.
+func ReadManifest(destination, id string, encryption *Encryption) (*Manifest, error) {
+	// Find the manifest, which may or may not be encrypted.
+	path := filepath.Join(destination, manifestsDir, id+".json.gz")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		path += encryptedExt
+	}
+
+	// Open the manifest file.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	// Look through the encryption layer, if any.
+	reader, _, err := plaintextReader(file, encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decompress and decode the manifest.
+	decompressor, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer decompressor.Close()
+
+	return decodeManifest(decompressor)
+}
+
+// RemoveManifest deletes the manifest of a snapshot, if it exists.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - id: The snapshot ID.
+
+// Returns:
+// - error: An error if an existing manifest cannot be removed.
+func RemoveManifest(destination, id string) error {
+	path := filepath.Join(destination, manifestsDir, id+".json.gz")
+	for _, candidate := range []string{path, path + encryptedExt} {
+		if err := os.Remove(candidate); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadArchiveManifest reads the manifest embedded in an archive.
+
+// Parameters:
+// - archivePath: The path to the archive file.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - *Manifest: The embedded manifest.
+// - error: An error if the archive cannot be read or has no manifest.
+func ReadArchiveManifest(archivePath string, encryption *Encryption) (*Manifest, error) {
+	// Repository snapshot trees are manifests in their own right.
+	if IsSnapshotTree(archivePath) {
+		tree, err := readSnapshotTree(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		manifest := &Manifest{}
+		for _, record := range tree.Entries {
+			manifest.Entries = append(manifest.Entries, record.Entry)
+		}
+		return manifest, nil
+	}
+
+	// Open the archive, detecting its format.
+	extractor, err := OpenExtractor(archivePath, encryption)
+	if err != nil {
+		return nil, err
+	}
+	defer extractor.Close()
+
+	// Scan for the manifest entry.
+	for {
+		entry, content, err := extractor.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("archive %s has no manifest", archivePath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Name == ManifestEntryName {
+			return decodeManifest(content)
+		}
+	}
+}
+
+// decodeManifest parses a JSON manifest.
+func decodeManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.NewDecoder(bufio.NewReader(r)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
@@ -1,71 +1,396 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// catalogDir is the directory below the destination holding one record per snapshot.
+const catalogDir = "catalog"
+
+// SnapshotType distinguishes how a snapshot relates to earlier ones.
+type SnapshotType string
+
+const (
+	// SnapshotFull contains the complete source tree.
+	SnapshotFull SnapshotType = "full"
+	// SnapshotIncremental contains the changes since its parent snapshot.
+	SnapshotIncremental SnapshotType = "incremental"
+	// SnapshotDifferential contains the changes since its base full snapshot.
+	SnapshotDifferential SnapshotType = "differential"
 )
 
-// Metadata holds the details of a backup operation
+// Metadata holds the details of a backup operation. Each snapshot has exactly
+// one record in the catalog of its destination, written once and never modified.
 type Metadata struct {
-	Source      string    `json:"source"`
-	Destination string    `json:"destination"`
-	Path        string    `json:"path"`
-	Time        time.Time `json:"time"`
+	ID          string       `json:"id"`
+	Type        SnapshotType `json:"type"`
+	Parent      string       `json:"parent,omitempty"`
+	Base        string       `json:"base,omitempty"`
+	Source      string       `json:"source"`
+	Destination string       `json:"-"`
+	Host        string       `json:"host"`
+	Path        string       `json:"path"`
+	StartTime   time.Time    `json:"start_time"`
+	EndTime     time.Time    `json:"end_time"`
+	FileCount   int          `json:"file_count"`
+	Bytes       int64        `json:"bytes"`
+	Checksum    string       `json:"checksum"`
 }
 
-// StoreMetadata saves the metadata to a JSON file.
+// NewSnapshotID generates a random identifier for a new snapshot.
+
+// Returns:
+// - string: A 16 character hexadecimal identifier.
+// - error: An error if the random source fails.
+func NewSnapshotID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate snapshot id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// StoreMetadata adds the record of a snapshot to the catalog of its destination.
+// The archive path is stored relative to the destination so the whole
+// destination directory can be moved or copied.
 
 // Parameters:
 // - metadata: The metadata information to store.
 
 // Returns:
-// - error: An error if marshaling or writing fails.
-func StoreMetadata(metatdata Metadata) error {
-	// Construct the metadata file path
-	metadataPath := filepath.Join(metatdata.Destination, "metadata.json")
+// - error: An error if marshaling or writing fails, or the record already exists.
+func StoreMetadata(metadata Metadata) error {
+	if metadata.ID == "" {
+		return fmt.Errorf("snapshot metadata has no id")
+	}
+
+	// Make sure the catalog exists.
+	dir := filepath.Join(metadata.Destination, catalogDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create catalog: %w", err)
+	}
+
+	// Records are immutable, so refuse to replace an existing one.
+	recordPath := filepath.Join(dir, metadata.ID+".json")
+	if _, err := os.Stat(recordPath); err == nil {
+		return fmt.Errorf("snapshot %s already exists in the catalog", metadata.ID)
+	}
+
+	// Store the archive path relative to the destination.
+	if rel, err := filepath.Rel(metadata.Destination, metadata.Path); err == nil {
+		metadata.Path = filepath.ToSlash(rel)
+	}
 
 	// Marshal metadata to JSON
-	data, err := json.Marshal(metatdata)
+	data, err := json.MarshalIndent(metadata, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	// Write JSON data to the metadata file.
-	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
+	// Write the record atomically so a crash never leaves a partial record.
+	if err := writeFileAtomic(recordPath, data); err != nil {
 		return fmt.Errorf("failed to write metadata file: %w", err)
 	}
 
 	return nil
 }
 
-// GetRecentMetadata retrieves the most recent metadata from a JSON file.
+// ListMetadata returns the records of all snapshots in the destination's catalog.
 
 // Parameters:
-// - destination: The directory containing the metadata file.
+// - destination: The directory where backups are stored.
 
 // Returns:
-// - Metadata: The metadata information from the file.
-// - error: An error if reading or unmarshalling fails.
-func GetRecentMetadata(destination string) (Metadata, error) {
-	// Construct the metadata file path
-	metadataPath := filepath.Join(destination, "metadata.json")
+// - []Metadata: The snapshot records, sorted oldest first.
+// - error: An error if the catalog cannot be read.
+func ListMetadata(destination string) ([]Metadata, error) {
+	// List the records of the catalog.
+	records, err := filepath.Glob(filepath.Join(destination, catalogDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalog: %w", err)
+	}
+
+	// Read every record.
+	snapshots := make([]Metadata, 0, len(records))
+	for _, recordPath := range records {
+		metadata, err := readMetadata(destination, recordPath)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, metadata)
+	}
+
+	// Sort the snapshots by start time, oldest first.
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].StartTime.Before(snapshots[j].StartTime)
+	})
+
+	return snapshots, nil
+}
+
+// GetMetadata retrieves the record of a single snapshot from the catalog.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - id: The snapshot ID, or an unambiguous prefix of it.
+
+// Returns:
+// - Metadata: The metadata of the snapshot.
+// - error: An error if no snapshot or more than one snapshot matches.
+func GetMetadata(destination, id string) (Metadata, error) {
+	// Read the whole catalog.
+	snapshots, err := ListMetadata(destination)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	// Collect the snapshots whose ID starts with the given one.
+	var matches []Metadata
+	for _, snapshot := range snapshots {
+		if snapshot.ID == id {
+			return snapshot, nil
+		}
+		if id != "" && strings.HasPrefix(snapshot.ID, id) {
+			matches = append(matches, snapshot)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Metadata{}, fmt.Errorf("snapshot %q not found", id)
+	case 1:
+		return matches[0], nil
+	}
+	return Metadata{}, fmt.Errorf("snapshot id %q is ambiguous", id)
+}
+
+// GetRecentMetadata retrieves the record of the most recent snapshot of a source.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - source: The absolute source directory of the snapshot; empty for any source.
+
+// Returns:
+//   - Metadata: The metadata of the newest snapshot.
+//   - error: An error if the catalog cannot be read, no snapshot matches, or
+//     the snapshots come from more than one source and none was given.
+func GetRecentMetadata(destination, source string) (Metadata, error) {
+	// Read the catalog, keeping the snapshots of the source.
+	snapshots, err := ListMetadata(destination)
+	if err != nil {
+		return Metadata{}, err
+	}
+	snapshots = snapshotsOf(snapshots, source)
 
+	// Check if there are any backups found.
+	if len(snapshots) == 0 {
+		if source != "" {
+			return Metadata{}, fmt.Errorf("no backups of %s found", source)
+		}
+		return Metadata{}, fmt.Errorf("no backups found")
+	}
+
+	// Refuse to guess between the snapshots of several sources.
+	if err := checkSingleSource(snapshots); err != nil {
+		return Metadata{}, err
+	}
+
+	// Return the most recent snapshot.
+	return snapshots[len(snapshots)-1], nil
+}
+
+// GetMetadataAsOf retrieves the record of the newest snapshot of a source
+// taken at or before a point in time.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - source: The absolute source directory of the snapshot; empty for any source.
+// - asOf: The point in time.
+
+// Returns:
+//   - Metadata: The metadata of the snapshot.
+//   - error: An error if the catalog cannot be read, no snapshot is old enough,
+//     or the snapshots old enough come from more than one source and none was given.
+func GetMetadataAsOf(destination, source string, asOf time.Time) (Metadata, error) {
+	// Read the catalog, keeping the snapshots of the source.
+	snapshots, err := ListMetadata(destination)
+	if err != nil {
+		return Metadata{}, err
+	}
+	snapshots = snapshotsOf(snapshots, source)
+
+	// Drop the snapshots taken after the point in time.
+	n := len(snapshots)
+	for n > 0 && snapshots[n-1].StartTime.After(asOf) {
+		n--
+	}
+	snapshots = snapshots[:n]
+	if len(snapshots) == 0 {
+		return Metadata{}, fmt.Errorf("no snapshot found as of %s", asOf.Format(time.RFC3339))
+	}
+
+	// Refuse to guess between the snapshots of several sources.
+	if err := checkSingleSource(snapshots); err != nil {
+		return Metadata{}, err
+	}
+
+	// Return the newest snapshot old enough.
+	return snapshots[len(snapshots)-1], nil
+}
+
+// snapshotsOf keeps the snapshots of a source.
+
+// Parameters:
+// - snapshots: The snapshots to filter, in catalog order.
+// - source: The absolute source directory; empty to keep every snapshot.
+
+// Returns:
+// - []Metadata: The snapshots of the source, in the same order.
+func snapshotsOf(snapshots []Metadata, source string) []Metadata {
+	if source == "" {
+		return snapshots
+	}
+	var kept []Metadata
+	for _, snapshot := range snapshots {
+		if snapshot.Source == source {
+			kept = append(kept, snapshot)
+		}
+	}
+	return kept
+}
+
+// checkSingleSource makes sure snapshots all come from the same source, so
+// that picking the newest one cannot silently pick another source's backup.
+
+// Parameters:
+// - snapshots: The candidate snapshots.
+
+// Returns:
+// - error: An error naming the sources if there is more than one.
+func checkSingleSource(snapshots []Metadata) error {
+	var sources []string
+	seen := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		if !seen[snapshot.Source] {
+			seen[snapshot.Source] = true
+			sources = append(sources, snapshot.Source)
+		}
+	}
+	if len(sources) > 1 {
+		sort.Strings(sources)
+		return fmt.Errorf("snapshots of several sources match (%s); choose a source or a snapshot ID", strings.Join(sources, ", "))
+	}
+	return nil
+}
+
+// SnapshotChain resolves the snapshots needed to reconstruct a snapshot: the
+// full snapshot it is based on followed by every incremental up to it. The
+// chain of a differential snapshot is its base followed by the differential.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - metadata: The snapshot to reconstruct.
+
+// Returns:
+// - []Metadata: The snapshots to apply, oldest first.
+// - error: An error if a parent is missing from the catalog or the chain loops.
+func SnapshotChain(destination string, metadata Metadata) ([]Metadata, error) {
+	// Index the catalog by ID.
+	snapshots, err := ListMetadata(destination)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Metadata, len(snapshots))
+	for _, snapshot := range snapshots {
+		byID[snapshot.ID] = snapshot
+	}
+
+	// Follow the parents back to the full snapshot.
+	chain := []Metadata{metadata}
+	for current := metadata; current.Type != SnapshotFull; {
+		parent, ok := byID[current.Parent]
+		if !ok {
+			return nil, fmt.Errorf("parent %s of snapshot %s is missing from the catalog", current.Parent, current.ID)
+		}
+		if len(chain) > len(snapshots) {
+			return nil, fmt.Errorf("snapshot chain of %s contains a loop", metadata.ID)
+		}
+		chain = append(chain, parent)
+		current = parent
+	}
+
+	// Reverse the chain so the full snapshot comes first.
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain, nil
+}
+
+// RemoveMetadata deletes the record of a snapshot from the catalog.
+
+// Parameters:
+// - metadata: The snapshot whose record is removed.
+
+// Returns:
+// - error: An error if the record cannot be removed.
+func RemoveMetadata(metadata Metadata) error {
+	recordPath := filepath.Join(metadata.Destination, catalogDir, metadata.ID+".json")
+	if err := os.Remove(recordPath); err != nil {
+		return fmt.Errorf("failed to remove catalog record: %w", err)
+	}
+	return nil
+}
+
+// FileChecksum computes the SHA-256 checksum of a file.
+
+// Parameters:
+// - path: The file to hash.
+
+// Returns:
+// - string: The hexadecimal checksum.
+// - error: An error if the file cannot be read.
+func FileChecksum(path string) (string, error) {
+	return fs.HashFile(path)
+}
+
+// readMetadata reads a single catalog record, resolving its archive path
+// against the destination it was read from.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+// - recordPath: The path of the catalog record.
+
+// Returns:
+// - Metadata: The metadata of the snapshot.
+// - error: An error if reading or unmarshalling fails.
+func readMetadata(destination, recordPath string) (Metadata, error) {
 	// Read the metadata file
-	data, err := os.ReadFile(metadataPath)
+	data, err := os.ReadFile(recordPath)
 	if err != nil {
 		return Metadata{}, fmt.Errorf("failed to read metadata file: %w", err)
 	}
 
 	var metadata Metadata
 
-	// Unmarshal JSON data into the Metadata struct.
+	// Unmarshal the JSON data into the Metadata struct.
 	if err := json.Unmarshal(data, &metadata); err != nil {
-		return Metadata{}, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		return Metadata{}, fmt.Errorf("failed to unmarshal metadata %s: %w", recordPath, err)
 	}
 
-	// Return the metadata on successful retrieval.
+	// Resolve the archive path against the destination it was read from.
+	metadata.Destination = destination
+	metadata.Path = filepath.Join(destination, filepath.FromSlash(metadata.Path))
+
 	return metadata, nil
 }
@@ -0,0 +1,472 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// Mode selects how backups are stored in the destination directory.
+type Mode string
+
+const (
+	// ModeArchive stores every backup as a self-contained archive file.
+	ModeArchive Mode = "archive"
+	// ModeRepository stores backups as snapshots referencing deduplicated chunks.
+	ModeRepository Mode = "repository"
+)
+
+// ParseMode converts a user supplied storage mode into a Mode.
+
+// Parameters:
+// - name: The mode name, "archive" or "repository".
+
+// Returns:
+// - Mode: The matching mode, or ModeArchive when the name is empty.
+// - error: An error if the name is not a known mode.
+func ParseMode(name string) (Mode, error) {
+	switch strings.ToLower(name) {
+	case "", "archive":
+		return ModeArchive, nil
+	case "repository", "repo":
+		return ModeRepository, nil
+	}
+
+	return "", fmt.Errorf("unknown storage mode %q", name)
+}
+
+// Repository layout below the destination directory:
+//
+//	repository/chunks/<xx>/<sha256>   zstd-compressed chunk contents
+//	repository/snapshots/<name>.tree  JSON tree of a snapshot
+const (
+	repositoryDir   = "repository"
+	chunksDir       = "chunks"
+	snapshotsDir    = "snapshots"
+	snapshotTreeExt = ".tree"
+)
+
+// snapshotTree is the on-disk representation of a repository snapshot.
+type snapshotTree struct {
+	Source  string      `json:"source"`
+	Time    time.Time   `json:"time"`
+	Entries []treeEntry `json:"entries"`
+}
+
+// treeEntry is an entry of a snapshot tree together with the chunks holding its contents.
+type treeEntry struct {
+	Entry
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// RepositoryPath returns the root of the chunk repository in the destination directory.
+
+// Parameters:
+// - destination: The directory where backups are stored.
+
+// Returns:
+// - string: The path of the repository.
+func RepositoryPath(destination string) string {
+	return filepath.Join(destination, repositoryDir)
+}
+
+// IsSnapshotTree reports whether the path names a repository snapshot tree.
+
+// Parameters:
+// - path: The file path to check.
+
+// Returns:
+// - bool: True if the path is a snapshot tree.
+func IsSnapshotTree(path string) bool {
+	return strings.HasSuffix(path, snapshotTreeExt)
+}
+
+// CreateSnapshot stores the source directory as a new snapshot in the chunk repository
+// of the destination. File contents are split into content-defined chunks and only
+// chunks not already present in the repository are written.
+
+// Parameters:
+// - destination: The directory holding the repository.
+// - source: The root directory to be backed up.
+// - followSymlinks: Whether to store symbolic links as their target.
+// - xattrs: The filter selecting the extended attributes to store; nil stores none.
+
+// Returns:
+// - string: The path to the snapshot tree.
+// - *Manifest: The manifest of the entries stored.
+// - error: An error if the snapshot cannot be created.
+func CreateSnapshot(destination, source string, followSymlinks bool, xattrs *fs.XattrFilter) (string, *Manifest, error) {
+	// Traverse the source directory to get a list of files and directories.
+	paths, err := fs.TraversalTree(source, followSymlinks)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to traverse directory: %w", err)
+	}
+
+	// Make sure the repository layout exists.
+	root := RepositoryPath(destination)
+	for _, dir := range []string{chunksDir, snapshotsDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return "", nil, fmt.Errorf("failed to create repository: %w", err)
+		}
+	}
+
+	// Generate a timestamp for the snapshot tree name, down to the
+	// nanosecond so backups taken within the same second get their own tree.
+	now := time.Now()
+	treePath := filepath.Join(root, snapshotsDir, fmt.Sprintf("backup_%s_%09d%s", now.Format("20060102150405"), now.Nanosecond(), snapshotTreeExt))
+
+	// Create the chunk encoder.
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	defer encoder.Close()
+
+	// Write the entries into the repository.
+	archiver := &repositoryArchiver{
+		root:     root,
+		treePath: treePath,
+		tree:     snapshotTree{Source: source, Time: now},
+		encoder:  encoder,
+	}
+	// The tree itself lists every entry, so no manifest is embedded.
+	manifest, err := writeEntries(archiver, source, paths, nil, followSymlinks, xattrs, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return treePath, manifest, nil
+}
+
+// repositoryArchiver implements Archiver by storing contents as chunks and
+// writing the snapshot tree when closed.
+type repositoryArchiver struct {
+	root     string
+	treePath string
+	tree     snapshotTree
+	encoder  *zstd.Encoder
+}
+
+// WriteEntry chunks the contents of the entry into the repository and records it in the tree.
+func (a *repositoryArchiver) WriteEntry(entry Entry, content io.Reader) error {
+	record := treeEntry{Entry: entry}
+
+	// Split regular file contents into chunks and store each one.
+	if entry.Type == fs.TypeFile && content != nil {
+		hash := sha256.New()
+		chunker := newChunker(io.TeeReader(content, hash))
+		for {
+			chunk, err := chunker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", entry.Name, err)
+			}
+
+			id, err := a.writeChunk(chunk)
+			if err != nil {
+				return err
+			}
+			record.Chunks = append(record.Chunks, id)
+		}
+		record.Hash = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	a.tree.Entries = append(a.tree.Entries, record)
+	return nil
+}
+
+// writeChunk stores a chunk under its SHA-256 unless it already exists.
+func (a *repositoryArchiver) writeChunk(chunk []byte) (string, error) {
+	// Address the chunk by the hash of its contents.
+	sum := sha256.Sum256(chunk)
+	id := hex.EncodeToString(sum[:])
+	path := chunkPath(a.root, id)
+
+	// Identical contents are already stored, so there is nothing to do.
+	if _, err := os.Stat(path); err == nil {
+		return id, nil
+	}
+
+	// Compress the chunk and write it atomically.
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+	if err := writeFileAtomic(path, a.encoder.EncodeAll(chunk, nil)); err != nil {
+		return "", fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	return id, nil
+}
+
+// Close writes the snapshot tree, making the snapshot visible.
+func (a *repositoryArchiver) Close() error {
+	// Marshal the tree to JSON.
+	data, err := json.Marshal(a.tree)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot tree: %w", err)
+	}
+
+	// Write the tree only after all of its chunks are stored, never
+	// replacing the tree of another snapshot.
+	if err := writeFileExclusive(a.treePath, data); err != nil {
+		return fmt.Errorf("failed to write snapshot tree: %w", err)
+	}
+
+	return nil
+}
+
+// repositoryExtractor implements Extractor for repository snapshots.
+type repositoryExtractor struct {
+	root    string
+	tree    snapshotTree
+	index   int
+	decoder *zstd.Decoder
+}
+
+// openRepositoryExtractor reads the snapshot tree at treePath.
+func openRepositoryExtractor(treePath string) (*repositoryExtractor, error) {
+	// Read the snapshot tree.
+	tree, err := readSnapshotTree(treePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the chunk decoder.
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+
+	// The repository root is two levels above the tree: repository/snapshots/<tree>.
+	root := filepath.Dir(filepath.Dir(treePath))
+	return &repositoryExtractor{root: root, tree: tree, decoder: decoder}, nil
+}
+
+// Next returns the next entry of the snapshot together with a reader over its chunks.
+func (e *repositoryExtractor) Next() (*Entry, io.Reader, error) {
+	// Signal the end of the snapshot.
+	if e.index >= len(e.tree.Entries) {
+		return nil, nil, io.EOF
+	}
+	record := e.tree.Entries[e.index]
+	e.index++
+
+	entry := record.Entry
+	return &entry, &chunkReader{extractor: e, chunks: record.Chunks}, nil
+}
+
+// Close releases the chunk decoder.
+func (e *repositoryExtractor) Close() error {
+	e.decoder.Close()
+	return nil
+}
+
+// readChunk loads a chunk and verifies that its contents match its address.
+func (e *repositoryExtractor) readChunk(id string) ([]byte, error) {
+	// Read the compressed chunk.
+	compressed, err := os.ReadFile(chunkPath(e.root, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+
+	// Decompress the chunk.
+	chunk, err := e.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %s: %w", id, err)
+	}
+
+	// Verify the contents against the chunk's address.
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupted", id)
+	}
+
+	return chunk, nil
+}
+
+// chunkReader streams the contents of an entry by loading its chunks in order.
+type chunkReader struct {
+	extractor *repositoryExtractor
+	chunks    []string
+	current   *bytes.Reader
+}
+
+// Read reads from the current chunk, loading the next one when it is exhausted.
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.current == nil || r.current.Len() == 0 {
+		// Signal the end of the contents once every chunk has been read.
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+
+		// Load the next chunk.
+		chunk, err := r.extractor.readChunk(r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		r.chunks = r.chunks[1:]
+		r.current = bytes.NewReader(chunk)
+	}
+
+	return r.current.Read(p)
+}
+
+// PruneRepository removes chunks that are no longer referenced by any snapshot
+// in the repository of the destination. It does nothing if no repository exists.
+
+// Parameters:
+// - destination: The directory holding the repository.
+
+// Returns:
+// - error: An error if the snapshots cannot be read or a chunk cannot be removed.
+func PruneRepository(destination string) error {
+	root := RepositoryPath(destination)
+
+	// Nothing to prune when the destination has no repository.
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Collect every chunk referenced by the remaining snapshots.
+	trees, err := filepath.Glob(filepath.Join(root, snapshotsDir, "*"+snapshotTreeExt))
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	referenced := make(map[string]bool)
+	for _, treePath := range trees {
+		tree, err := readSnapshotTree(treePath)
+		if err != nil {
+			return err
+		}
+		for _, record := range tree.Entries {
+			for _, id := range record.Chunks {
+				referenced[id] = true
+			}
+		}
+	}
+
+	// Remove every stored chunk that is no longer referenced.
+	chunks, err := fs.TraversalDirectory(filepath.Join(root, chunksDir))
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %w", err)
+	}
+	for _, path := range chunks {
+		if !referenced[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove unreferenced chunk: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readSnapshotTree reads and parses a snapshot tree.
+func readSnapshotTree(treePath string) (snapshotTree, error) {
+	// Read the tree file.
+	data, err := os.ReadFile(treePath)
+	if err != nil {
+		return snapshotTree{}, fmt.Errorf("failed to read snapshot tree: %w", err)
+	}
+
+	// Unmarshal the JSON data into the tree.
+	var tree snapshotTree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return snapshotTree{}, fmt.Errorf("failed to parse snapshot tree %s: %w", treePath, err)
+	}
+
+	return tree, nil
+}
+
+// chunkPath returns the path of a chunk, fanned out by the first two hex digits.
+func chunkPath(root, id string) string {
+	return filepath.Join(root, chunksDir, id[:2], id)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never observe a partially written file.
+
+// Parameters:
+// - path: The final path of the file.
+// - data: The contents to write.
+
+// Returns:
+// - error: An error if writing, syncing or renaming fails.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// Move the file into place.
+	return os.Rename(tmp, path)
+}
+
+// writeFileExclusive writes data to a temporary file next to path and links
+// it into place, so readers never observe a partially written file and an
+// existing file at path is never replaced.
+
+// Parameters:
+// - path: The final path of the file.
+// - data: The contents to write.
+
+// Returns:
+// - error: An error if writing or syncing fails, or if path already exists.
+func writeFileExclusive(path string, data []byte) error {
+	tmp, err := writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// Link the file into place, which fails if the path is taken.
+	return os.Link(tmp, path)
+}
+
+// writeTemp writes and syncs data to a new temporary file in a directory.
+
+// Parameters:
+// - dir: The directory to create the file in.
+// - data: The contents to write.
+
+// Returns:
+// - string: The path of the temporary file.
+// - error: An error if creating, writing or syncing fails.
+func writeTemp(dir string, data []byte) (string, error) {
+	// Create a temporary file in the directory.
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	// Write and sync the contents.
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
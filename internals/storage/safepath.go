@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UnsafePathError reports an archive entry that would be written outside the
+// restore root, either because its name escapes it or because it would be
+// written through a symbolic link.
+type UnsafePathError struct {
+	// Name is the slash-separated name recorded in the archive.
+	Name string
+	// Reason describes why the name was rejected.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("unsafe path %q: %s", e.Name, e.Reason)
+}
+
+// SafePath resolves an archive name to a path below the destination directory.
+// Absolute names, names with ".." components and names whose parent
+// directories below the destination are symbolic links are rejected, so
+// neither a crafted archive nor a link restored earlier can redirect a write
+// outside the restore root. The final component is not checked, as callers
+// replace whatever is there.
+
+// Parameters:
+// - destination: The directory the archive is being restored into.
+// - name: The slash-separated name recorded in the archive.
+
+// Returns:
+// - string: The path of the entry below the destination.
+// - error: An *UnsafePathError if the name is unsafe, or an error if a parent cannot be inspected.
+func SafePath(destination, name string) (string, error) {
+	// Archive names are always relative and slash-separated.
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return "", &UnsafePathError{Name: name, Reason: "not a relative slash-separated path"}
+	}
+	rel := filepath.FromSlash(name)
+	if !filepath.IsLocal(rel) {
+		return "", &UnsafePathError{Name: name, Reason: "escapes the destination"}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", &UnsafePathError{Name: name, Reason: "contains a \"..\" component"}
+		}
+	}
+
+	// Refuse to descend through symbolic links below the destination.
+	parent := destination
+	parts := strings.Split(filepath.Clean(rel), string(filepath.Separator))
+	for i, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			// Nothing further down exists yet, so there are no links to follow.
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to inspect %s: %w", parent, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", &UnsafePathError{Name: name, Reason: fmt.Sprintf("parent %s is a symbolic link", strings.Join(parts[:i+1], "/"))}
+		}
+	}
+
+	return filepath.Join(destination, rel), nil
+}
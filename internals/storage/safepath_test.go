@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSafePath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		unsafe bool
+	}{
+		{"file", false},
+		{"dir/file", false},
+		{"missing/deeper/file", false},
+		// The last component may be a link; it is replaced, never followed.
+		{"link", false},
+		{"", true},
+		{"/etc/passwd", true},
+		{"../escape", true},
+		{"dir/../../escape", true},
+		{"dir/..", true},
+		{"dir\\..\\escape", true},
+		{"link/escape", true},
+		{"link/deeper/escape", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SafePath(root, tt.name)
+			var unsafe *UnsafePathError
+			if tt.unsafe {
+				if !errors.As(err, &unsafe) {
+					t.Fatalf("SafePath(%q) = %q, %v; want an *UnsafePathError", tt.name, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SafePath(%q): %v", tt.name, err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(tt.name)); got != want {
+				t.Errorf("SafePath(%q) = %q, want %q", tt.name, got, want)
+			}
+		})
+	}
+}
+
+// craftedEntry is an entry of an archive built by hand, bypassing the
+// checks goback applies when it writes archives itself.
+type craftedEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+// writeCraftedTar writes entries into a tar archive.
+func writeCraftedTar(t *testing.T, path string, entries []craftedEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := tar.NewWriter(file)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeCraftedZip writes entries into a zip archive, encoding links the way
+// goback does: symbolic links by their mode and hard links by an extra field,
+// both with their target as contents.
+func writeCraftedZip(t *testing.T, path string, entries []craftedEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		content := e.content
+		switch e.typeflag {
+		case tar.TypeDir:
+			header.Name += "/"
+			header.SetMode(os.ModeDir | 0755)
+		case tar.TypeSymlink:
+			header.SetMode(os.ModeSymlink | 0777)
+			content = e.linkname
+		case tar.TypeLink:
+			header.SetMode(0644)
+			header.Extra = hardlinkExtra()
+			content = e.linkname
+		default:
+			header.SetMode(0644)
+		}
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listTree returns every path below a directory with the contents of its files.
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		entry := path + " " + info.Mode().String()
+		if info.Mode().IsRegular() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			entry += " " + string(data)
+		}
+		paths = append(paths, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name string
+		// entries are crafted with "OUTSIDE" standing for the absolute path
+		// of a directory next to the restore root.
+		entries []craftedEntry
+		// existingLink is a link to the outside directory already present in the root.
+		existingLink string
+	}{
+		{name: "dot-dot file", entries: []craftedEntry{{name: "../outside/evil", content: "pwned"}}},
+		{name: "nested dot-dot file", entries: []craftedEntry{{name: "dir", typeflag: tar.TypeDir}, {name: "dir/../../outside/evil", content: "pwned"}}},
+		{name: "absolute file", entries: []craftedEntry{{name: "OUTSIDE/evil", content: "pwned"}}},
+		{name: "dot-dot directory", entries: []craftedEntry{{name: "../outside/newdir", typeflag: tar.TypeDir}}},
+		{name: "dot-dot symlink", entries: []craftedEntry{{name: "../outside/evil", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}}},
+		{name: "file through archived symlink", entries: []craftedEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+			{name: "link/evil", content: "pwned"},
+		}},
+		{name: "file through relative archived symlink", entries: []craftedEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "../outside"},
+			{name: "link/evil", content: "pwned"},
+		}},
+		{name: "file through existing symlink", existingLink: "link", entries: []craftedEntry{{name: "link/evil", content: "pwned"}}},
+		{name: "hard link to dot-dot target", entries: []craftedEntry{{name: "evil", typeflag: tar.TypeLink, linkname: "../outside/secret"}}},
+		{name: "hard link to absolute target", entries: []craftedEntry{{name: "evil", typeflag: tar.TypeLink, linkname: "OUTSIDE/secret"}}},
+		{name: "hard link through archived symlink", entries: []craftedEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+			{name: "evil", typeflag: tar.TypeLink, linkname: "link/secret"},
+		}},
+	}
+
+	writers := map[string]func(*testing.T, string, []craftedEntry){"tar": writeCraftedTar, "zip": writeCraftedZip}
+	for format, write := range writers {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				base := t.TempDir()
+				root := filepath.Join(base, "root")
+				outside := filepath.Join(base, "outside")
+				for _, dir := range []string{root, outside} {
+					if err := os.Mkdir(dir, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if tt.existingLink != "" {
+					if err := os.Symlink(outside, filepath.Join(root, tt.existingLink)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// Build the archive, filling in the absolute path of the outside directory.
+				entries := make([]craftedEntry, len(tt.entries))
+				for i, e := range tt.entries {
+					e.name = strings.ReplaceAll(e.name, "OUTSIDE", outside)
+					e.linkname = strings.ReplaceAll(e.linkname, "OUTSIDE", outside)
+					entries[i] = e
+				}
+				archivePath := filepath.Join(t.TempDir(), "crafted."+format)
+				write(t, archivePath, entries)
+
+				before := listTree(t, outside)
+				_, err := ExtractArchive(archivePath, root, nil, nil)
+				var unsafe *UnsafePathError
+				if !errors.As(err, &unsafe) {
+					t.Errorf("ExtractArchive = %v, want an *UnsafePathError", err)
+				}
+
+				// Nothing outside the root may have changed, and no link into it may exist.
+				if after := listTree(t, outside); strings.Join(after, "\n") != strings.Join(before, "\n") {
+					t.Errorf("outside directory changed:\nbefore %q\nafter  %q", before, after)
+				}
+				if _, err := os.Lstat(filepath.Join(root, "evil")); err == nil {
+					t.Error("root/evil was created")
+				}
+				entries2, err := os.ReadDir(base)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries2) != 2 {
+					t.Errorf("base directory holds %d entries, want only root and outside", len(entries2))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// paxXattrPrefix prefixes the PAX records holding extended attributes.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// PAX records describing sparse files: the data segments as a comma-separated
+// list of offsets and lengths, and the full size of the file. Only the data
+// segments are stored as the entry's contents.
+const (
+	paxSparseMap  = "GOBACK.sparse.map"
+	paxSparseSize = "GOBACK.sparse.size"
+)
+
+// tarArchiver implements Archiver for plain and compressed tar archives.
+type tarArchiver struct {
+	writer     *tar.Writer
+	compressor io.WriteCloser
+}
+
+// newTarArchiver creates a tar Archiver writing to w, compressed according to the format.
+func newTarArchiver(w io.Writer, format Format) (*tarArchiver, error) {
+	archiver := &tarArchiver{}
+
+	// Wrap the writer in the compressor of the format, if any.
+	switch format {
+	case FormatTarGz:
+		archiver.compressor = gzip.NewWriter(w)
+	case FormatTarZst:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		archiver.compressor = encoder
+	}
+	if archiver.compressor != nil {
+		w = archiver.compressor
+	}
+
+	archiver.writer = tar.NewWriter(w)
+	return archiver, nil
+}
+
+// WriteEntry adds an entry to the tar archive.
+func (a *tarArchiver) WriteEntry(entry Entry, content io.Reader) error {
+	// Build the tar header from the entry. PAX allows long names and large ids.
+	header := &tar.Header{
+		Name:       entry.Name,
+		Mode:       tarMode(entry.Mode),
+		ModTime:    entry.ModTime,
+		AccessTime: entry.AccessTime,
+		Uid:        entry.UID,
+		Gid:        entry.GID,
+		Linkname:   entry.Linkname,
+		Devmajor:   entry.DevMajor,
+		Devminor:   entry.DevMinor,
+		Format:     tar.FormatPAX,
+	}
+
+	// Store extended attributes as PAX records, as GNU tar and bsdtar do.
+	for name, value := range entry.Xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string, len(entry.Xattrs))
+		}
+		header.PAXRecords[paxXattrPrefix+name] = string(value)
+	}
+
+	// Map the entry type onto the tar type flag.
+	switch entry.Type {
+	case fs.TypeFile:
+		header.Typeflag = tar.TypeReg
+		header.Size = entry.Size
+		if entry.Sparse != nil {
+			// Store only the data segments of sparse files.
+			header.Size = setSparseRecords(header, entry)
+			content = fs.SparseData(content, entry.Sparse)
+		}
+	case fs.TypeDir:
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+	case fs.TypeSymlink:
+		header.Typeflag = tar.TypeSymlink
+	case fs.TypeHardlink:
+		header.Typeflag = tar.TypeLink
+	case fs.TypeCharDevice:
+		header.Typeflag = tar.TypeChar
+	case fs.TypeBlockDevice:
+		header.Typeflag = tar.TypeBlock
+	case fs.TypeFIFO:
+		header.Typeflag = tar.TypeFifo
+	default:
+		return fmt.Errorf("tar format cannot store %s entry %s", entry.Type, entry.Name)
+	}
+
+	// Write the header.
+	if err := a.writer.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+
+	// Copy the contents of regular files into the archive.
+	if header.Typeflag == tar.TypeReg {
+		if _, err := io.Copy(a.writer, content); err != nil {
+			return fmt.Errorf("failed to copy file to archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close flushes the tar archive and its compressor.
+func (a *tarArchiver) Close() error {
+	if err := a.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if a.compressor != nil {
+		if err := a.compressor.Close(); err != nil {
+			return fmt.Errorf("failed to close compressor: %w", err)
+		}
+	}
+	return nil
+}
+
+// tarExtractor implements Extractor for plain and compressed tar archives.
+type tarExtractor struct {
+	closer       io.Closer
+	decompressor io.Closer
+	reader       *tar.Reader
+}
+
+// newTarExtractor creates a tar Extractor reading the archive stream from r,
+// releasing the underlying file through closer when done.
+func newTarExtractor(file io.Reader, format Format, closer io.Closer) (*tarExtractor, error) {
+	extractor := &tarExtractor{closer: closer}
+	var r io.Reader = file
+
+	// Wrap the file in the decompressor of the format, if any.
+	switch format {
+	case FormatTarGz:
+		decompressor, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		extractor.decompressor = decompressor
+		r = decompressor
+	case FormatTarZst:
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		extractor.decompressor = decoder.IOReadCloser()
+		r = decoder
+	}
+
+	extractor.reader = tar.NewReader(r)
+	return extractor, nil
+}
+
+// Next returns the next entry of the tar archive.
+func (e *tarExtractor) Next() (*Entry, io.Reader, error) {
+	// Read the next header.
+	header, err := e.reader.Next()
+	if err == io.EOF {
+		return nil, nil, io.EOF
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read tar header: %w", err)
+	}
+
+	// Translate the tar header into an entry.
+	entry := &Entry{
+		Name:       strings.TrimSuffix(header.Name, "/"),
+		Mode:       os.FileMode(header.Mode) & os.ModePerm,
+		ModTime:    header.ModTime,
+		AccessTime: header.AccessTime,
+		UID:        header.Uid,
+		GID:        header.Gid,
+		Linkname:   header.Linkname,
+		DevMajor:   header.Devmajor,
+		DevMinor:   header.Devminor,
+	}
+	entry.Mode |= header.FileInfo().Mode() & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+
+	// Collect the extended attributes from the PAX records.
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, paxXattrPrefix); ok {
+			if entry.Xattrs == nil {
+				entry.Xattrs = make(map[string][]byte)
+			}
+			entry.Xattrs[name] = []byte(value)
+		}
+	}
+
+	// Map the tar type flag onto the entry type.
+	var content io.Reader = e.reader
+	switch header.Typeflag {
+	case tar.TypeReg:
+		entry.Type = fs.TypeFile
+		entry.Size = header.Size
+		if _, ok := header.PAXRecords[paxSparseMap]; ok {
+			// Expand the data segments of sparse files into their full contents.
+			if entry.Sparse, entry.Size, err = parseSparseRecords(header); err != nil {
+				return nil, nil, err
+			}
+			content = fs.NewSparseReader(e.reader, entry.Sparse, entry.Size)
+		}
+	case tar.TypeDir:
+		entry.Type = fs.TypeDir
+	case tar.TypeSymlink:
+		entry.Type = fs.TypeSymlink
+	case tar.TypeLink:
+		entry.Type = fs.TypeHardlink
+	case tar.TypeChar:
+		entry.Type = fs.TypeCharDevice
+	case tar.TypeBlock:
+		entry.Type = fs.TypeBlockDevice
+	case tar.TypeFifo:
+		entry.Type = fs.TypeFIFO
+	default:
+		return nil, nil, fmt.Errorf("unsupported tar entry type %q for %s", header.Typeflag, header.Name)
+	}
+
+	return entry, content, nil
+}
+
+// Close releases the decompressor and closes the archive file.
+func (e *tarExtractor) Close() error {
+	if e.decompressor != nil {
+		e.decompressor.Close()
+	}
+	return e.closer.Close()
+}
+
+// tarMode converts permission bits into the mode field of a tar header.
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode & os.ModePerm)
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// setSparseRecords describes the data segments of a sparse file in the PAX
+// records of its header.
+
+// Parameters:
+// - header: The tar header of the entry.
+// - entry: The sparse file entry.
+
+// Returns:
+// - int64: The number of data bytes stored for the entry.
+func setSparseRecords(header *tar.Header, entry Entry) int64 {
+	var stored int64
+	fields := make([]string, 0, 2*len(entry.Sparse))
+	for _, segment := range entry.Sparse {
+		fields = append(fields, strconv.FormatInt(segment.Offset, 10), strconv.FormatInt(segment.Length, 10))
+		stored += segment.Length
+	}
+
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string, 2)
+	}
+	header.PAXRecords[paxSparseMap] = strings.Join(fields, ",")
+	header.PAXRecords[paxSparseSize] = strconv.FormatInt(entry.Size, 10)
+	return stored
+}
+
+// parseSparseRecords reads the data segments and full size of a sparse file
+// from the PAX records of its header.
+
+// Parameters:
+// - header: The tar header of the entry.
+
+// Returns:
+// - []fs.Segment: The data segments of the file.
+// - int64: The full size of the file.
+// - error: An error if the records are malformed.
+func parseSparseRecords(header *tar.Header) ([]fs.Segment, int64, error) {
+	// Parse the full size of the file.
+	size, err := strconv.ParseInt(header.PAXRecords[paxSparseSize], 10, 64)
+	if err != nil || size < 0 {
+		return nil, 0, fmt.Errorf("invalid sparse size for %s", header.Name)
+	}
+
+	// Parse the offsets and lengths, which must be ordered and add up to the stored data.
+	fields := strings.Split(header.PAXRecords[paxSparseMap], ",")
+	if len(fields)%2 != 0 {
+		return nil, 0, fmt.Errorf("invalid sparse map for %s", header.Name)
+	}
+	segments := make([]fs.Segment, 0, len(fields)/2)
+	var end, stored int64
+	for i := 0; i < len(fields); i += 2 {
+		offset, err1 := strconv.ParseInt(fields[i], 10, 64)
+		length, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || offset < end || length < 0 || offset+length > size {
+			return nil, 0, fmt.Errorf("invalid sparse map for %s", header.Name)
+		}
+		segments = append(segments, fs.Segment{Offset: offset, Length: length})
+		end = offset + length
+		stored += length
+	}
+	if stored != header.Size {
+		return nil, 0, fmt.Errorf("invalid sparse map for %s", header.Name)
+	}
+
+	return segments, size, nil
+}
@@ -1,75 +1,110 @@
 package storage
 
 import (
-	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 )
 
-// VerifyBackup verifies the integrity and contents of a zip archive.
+// VerifyBackup verifies the integrity and contents of a backup archive.
+// The archive format is detected automatically and every entry is read
+// in full so that the checksums of the container, and the authentication
+// tags of encrypted archives, are validated. The contents are then checked
+// against the hashes in the archive's manifest.
 
 // Parameters:
-// - archivePath: The file path to the zip archive that needs to be verified.
+// - archivePath: The file path to the archive that needs to be verified.
+// - encryption: The encryption settings, used only if the archive is encrypted.
 
 // Returns:
 // - error: An error if verification or extraction fails.
-func VerifyBackup(archivePath string) error {
-	// Open the zip archive file
-	file, err := os.Open(archivePath)
+func VerifyBackup(archivePath string, encryption *Encryption) error {
+	// Open the archive, detecting its format.
+	extractor, err := OpenExtractor(archivePath, encryption)
 	if err != nil {
-		return fmt.Errorf("failed to open archive file: %w", err)
+		return err
 	}
 
-	// Ensure the file is closed when the function exits.
-	defer file.Close()
+	// Ensure the archive is closed when the function exits.
+	defer extractor.Close()
 
-	// Get file information for the zip archive.
-	info, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get archive file info: %w", err)
-	}
-
-	// Create a new zip reader to read archive's contents.
-	zipReader, err := zip.NewReader(file, info.Size())
-	if err != nil {
-		return fmt.Errorf("failed to create zip reader: %w", err)
-	}
+	// Record the hash of every entry read from the archive.
+	hashes := make(map[string]string)
+	var manifest *Manifest
 
-	// Iterate over each file inside the zip archive
-	for _, file := range zipReader.File {
-		// Open the current file inside the archive
-		srcFile, err := file.Open()
+	// Iterate over each entry inside the archive
+	for {
+		entry, content, err := extractor.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return fmt.Errorf("failed to open source file: %w", err)
+			return fmt.Errorf("failed to read archive entry: %w", err)
 		}
 
-		// Ensure the file is closed when done.
-		defer srcFile.Close()
-
-		// Define the destination path where the file will be extracted.
-		destPath := filepath.Join("/tmp", file.Name)
+		// The embedded manifest is what the other entries are checked against.
+		if entry.Name == ManifestEntryName {
+			if manifest, err = decodeManifest(content); err != nil {
+				return err
+			}
+			continue
+		}
 
-		// Create the destination directory if it does not exist.
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			return fmt.Errorf("failed to create destination directory: %w", err)
+		// Read the contents of the entry, which validates its checksum.
+		hash := sha256.New()
+		if _, err := io.Copy(hash, content); err != nil {
+			return fmt.Errorf("failed to verify %s: %w", entry.Name, err)
 		}
+		sum := hex.EncodeToString(hash.Sum(nil))
 
-		// Create the destination file where the content will be copied.
-		destFile, err := os.Create(destPath)
-		if err != nil {
-			return fmt.Errorf("failed to create destination file: %w", err)
+		// Snapshot trees carry the expected hash on each entry.
+		if entry.Hash != "" && entry.Hash != sum {
+			return fmt.Errorf("failed to verify %s: content hash mismatch", entry.Name)
 		}
+		hashes[entry.Name] = sum
+	}
 
-		// Ensure the file is closed when done.
-		defer destFile.Close()
+	// Archives written before manifests existed have nothing more to check.
+	if manifest == nil {
+		return nil
+	}
 
-		// Copy the contents of the current file to the destination file.
-		if _, err := io.Copy(destFile, srcFile); err != nil {
-			return fmt.Errorf("failed to copy file to destination: %w", err)
+	// Every manifest entry must be present with the recorded contents.
+	for _, expected := range manifest.Entries {
+		sum, ok := hashes[expected.Name]
+		if !ok {
+			return fmt.Errorf("failed to verify %s: missing from archive", expected.Name)
+		}
+		if expected.Hash != "" && expected.Hash != sum {
+			return fmt.Errorf("failed to verify %s: content hash mismatch", expected.Name)
 		}
 	}
 
 	return nil
 }
+
+// VerifySnapshot checks that the archive of a snapshot still matches the
+// checksum recorded in the catalog and then verifies its contents.
+
+// Parameters:
+// - metadata: The catalog record of the snapshot.
+// - encryption: The encryption settings, used only if the archive is encrypted.
+
+// Returns:
+// - error: An error if the archive was modified or fails verification.
+func VerifySnapshot(metadata Metadata, encryption *Encryption) error {
+	// Compare the archive against the recorded checksum.
+	if metadata.Checksum != "" {
+		checksum, err := FileChecksum(metadata.Path)
+		if err != nil {
+			return err
+		}
+		if checksum != metadata.Checksum {
+			return fmt.Errorf("checksum mismatch for %s: archive was modified", metadata.Path)
+		}
+	}
+
+	// Read the archive back in full.
+	return VerifyBackup(metadata.Path, encryption)
+}
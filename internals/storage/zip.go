@@ -0,0 +1,486 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ppriyankuu/goback/internals/fs"
+)
+
+// unixExtraID is the Info-ZIP "ux" extra field carrying the owner and group.
+const unixExtraID = 0x7875
+
+// hardlinkExtraID is goback's own empty "hl" extra field marking entries that
+// are hard links; like symlinks, they store their target as contents.
+const hardlinkExtraID = 0x6c68
+
+// deviceExtraID is goback's own "dv" extra field holding the major and minor
+// numbers of device nodes as two 32-bit values.
+const deviceExtraID = 0x7664
+
+// sparseExtraID is goback's own "sp" extra field marking sparse files and
+// holding their full size. Their contents start with the number of data
+// segments and the offset and length of each, followed by the data itself.
+const sparseExtraID = 0x7073
+
+// xattrExtraID is goback's own "xa" extra field holding extended attributes
+// as a sequence of length-prefixed names and values.
+const xattrExtraID = 0x6178
+
+// zipArchiver implements Archiver for the zip format.
+type zipArchiver struct {
+	writer *zip.Writer
+}
+
+// newZipArchiver creates a zip Archiver writing to w.
+func newZipArchiver(w io.Writer) *zipArchiver {
+	return &zipArchiver{writer: zip.NewWriter(w)}
+}
+
+// WriteEntry adds an entry to the zip archive.
+func (a *zipArchiver) WriteEntry(entry Entry, content io.Reader) error {
+	// Build the zip header from the entry.
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Modified: entry.ModTime,
+		Extra:    unixExtra(entry.UID, entry.GID),
+	}
+
+	// Store extended attributes in an extra field of their own.
+	if len(entry.Xattrs) > 0 {
+		extra, err := xattrExtra(entry.Xattrs)
+		if err != nil {
+			return fmt.Errorf("zip format cannot store %s: %w", entry.Name, err)
+		}
+		header.Extra = append(header.Extra, extra...)
+	}
+
+	// Map the entry type onto the zip file mode.
+	switch entry.Type {
+	case fs.TypeDir:
+		// Directories are stored as explicit, empty entries with a trailing slash.
+		header.Name += "/"
+		header.Method = zip.Store
+		header.SetMode(os.ModeDir | entry.Mode)
+		content = nil
+	case fs.TypeSymlink:
+		// Symlinks store their target as the entry contents.
+		header.Method = zip.Store
+		header.SetMode(os.ModeSymlink | entry.Mode)
+		content = strings.NewReader(entry.Linkname)
+	case fs.TypeHardlink:
+		// Hard links store the name of the entry they link to as contents.
+		header.Method = zip.Store
+		header.SetMode(entry.Mode)
+		header.Extra = append(header.Extra, hardlinkExtra()...)
+		content = strings.NewReader(entry.Linkname)
+	case fs.TypeFIFO:
+		// Named pipes are stored by type only.
+		header.Method = zip.Store
+		header.SetMode(os.ModeNamedPipe | entry.Mode)
+		content = nil
+	case fs.TypeCharDevice, fs.TypeBlockDevice:
+		// Device nodes carry their numbers in an extra field.
+		header.Method = zip.Store
+		mode := os.ModeDevice | entry.Mode
+		if entry.Type == fs.TypeCharDevice {
+			mode |= os.ModeCharDevice
+		}
+		header.SetMode(mode)
+		header.Extra = append(header.Extra, deviceExtra(entry.DevMajor, entry.DevMinor)...)
+		content = nil
+	case fs.TypeFile:
+		header.Method = zip.Deflate
+		header.SetMode(entry.Mode)
+		if entry.Sparse != nil {
+			// Store only the data segments of sparse files, after their map.
+			header.Extra = append(header.Extra, sparseExtra(entry.Size)...)
+			content = io.MultiReader(bytes.NewReader(encodeSparseMap(entry.Sparse)), fs.SparseData(content, entry.Sparse))
+		}
+	default:
+		return fmt.Errorf("zip format cannot store %s entry %s", entry.Type, entry.Name)
+	}
+
+	// Create a writer for the entry.
+	writer, err := a.writer.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to create zip header: %w", err)
+	}
+
+	// Copy the contents into the archive.
+	if content != nil {
+		if _, err := io.Copy(writer, content); err != nil {
+			return fmt.Errorf("failed to copy file to archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close writes the zip central directory.
+func (a *zipArchiver) Close() error {
+	return a.writer.Close()
+}
+
+// zipExtractor implements Extractor for the zip format.
+type zipExtractor struct {
+	closer io.Closer
+	reader *zip.Reader
+	index  int
+	open   io.ReadCloser
+}
+
+// newZipExtractor creates a zip Extractor reading from the given file,
+// releasing it through closer when done.
+func newZipExtractor(file io.ReaderAt, size int64, closer io.Closer) (*zipExtractor, error) {
+	// Create a new zip reader for the file.
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zip reader: %w", err)
+	}
+
+	return &zipExtractor{closer: closer, reader: reader}, nil
+}
+
+// Next returns the next entry of the zip archive.
+func (e *zipExtractor) Next() (*Entry, io.Reader, error) {
+	// Close the contents of the previous entry.
+	if e.open != nil {
+		e.open.Close()
+		e.open = nil
+	}
+
+	// Signal the end of the archive.
+	if e.index >= len(e.reader.File) {
+		return nil, nil, io.EOF
+	}
+	zf := e.reader.File[e.index]
+	e.index++
+
+	// Translate the zip header into an entry.
+	mode := zf.Mode()
+	entry := &Entry{
+		Name:    strings.TrimSuffix(zf.Name, "/"),
+		Type:    fs.TypeFile,
+		Mode:    mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+		Size:    int64(zf.UncompressedSize64),
+		ModTime: zf.Modified,
+	}
+	entry.UID, entry.GID = parseUnixExtra(zf.Extra)
+	entry.Xattrs = parseXattrExtra(zf.Extra)
+
+	// Directories and special files have no contents.
+	switch {
+	case mode.IsDir():
+		entry.Type = fs.TypeDir
+	case mode&os.ModeNamedPipe != 0:
+		entry.Type = fs.TypeFIFO
+	case mode&os.ModeCharDevice != 0:
+		entry.Type = fs.TypeCharDevice
+		entry.DevMajor, entry.DevMinor = parseDeviceExtra(zf.Extra)
+	case mode&os.ModeDevice != 0:
+		entry.Type = fs.TypeBlockDevice
+		entry.DevMajor, entry.DevMinor = parseDeviceExtra(zf.Extra)
+	}
+	if entry.Type != fs.TypeFile {
+		entry.Size = 0
+		return entry, bytes.NewReader(nil), nil
+	}
+
+	// Open the contents of the entry.
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s in archive: %w", zf.Name, err)
+	}
+
+	// Symlinks carry their target as contents.
+	if mode&os.ModeSymlink != 0 {
+		target, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read symlink %s: %w", zf.Name, err)
+		}
+		entry.Type = fs.TypeSymlink
+		entry.Linkname = string(target)
+		entry.Size = 0
+		return entry, bytes.NewReader(nil), nil
+	}
+
+	// So do hard links.
+	if hasExtra(zf.Extra, hardlinkExtraID) {
+		target, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read hard link %s: %w", zf.Name, err)
+		}
+		entry.Type = fs.TypeHardlink
+		entry.Linkname = string(target)
+		entry.Size = 0
+		return entry, bytes.NewReader(nil), nil
+	}
+
+	e.open = rc
+
+	// Sparse files start with the map of their data segments.
+	if size, ok := parseSparseExtra(zf.Extra); ok {
+		if entry.Sparse, err = decodeSparseMap(rc, size); err != nil {
+			return nil, nil, fmt.Errorf("failed to read sparse map of %s: %w", zf.Name, err)
+		}
+		entry.Size = size
+		return entry, fs.NewSparseReader(rc, entry.Sparse, size), nil
+	}
+
+	return entry, rc, nil
+}
+
+// Close closes the archive file.
+func (e *zipExtractor) Close() error {
+	if e.open != nil {
+		e.open.Close()
+	}
+	return e.closer.Close()
+}
+
+// unixExtra encodes the owner and group as an Info-ZIP "ux" extra field.
+func unixExtra(uid, gid int) []byte {
+	extra := make([]byte, 15)
+	binary.LittleEndian.PutUint16(extra[0:], unixExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 11)
+	extra[4] = 1 // version
+	extra[5] = 4 // UID size
+	binary.LittleEndian.PutUint32(extra[6:], uint32(uid))
+	extra[10] = 4 // GID size
+	binary.LittleEndian.PutUint32(extra[11:], uint32(gid))
+	return extra
+}
+
+// parseUnixExtra extracts the owner and group from an Info-ZIP "ux" extra field,
+// returning zero values if the field is absent.
+func parseUnixExtra(extra []byte) (int, int) {
+	// Walk the list of extra fields looking for the "ux" field.
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		// Only 32-bit identifiers of version 1 are understood.
+		if id != unixExtraID || size != 11 || field[0] != 1 || field[1] != 4 || field[6] != 4 {
+			continue
+		}
+		return int(binary.LittleEndian.Uint32(field[2:])), int(binary.LittleEndian.Uint32(field[7:]))
+	}
+
+	return 0, 0
+}
+
+// hardlinkExtra encodes the empty extra field marking hard link entries.
+func hardlinkExtra() []byte {
+	extra := make([]byte, 4)
+	binary.LittleEndian.PutUint16(extra[0:], hardlinkExtraID)
+	return extra
+}
+
+// hasExtra reports whether the list of extra fields contains a field with the given ID.
+func hasExtra(extra []byte, id uint16) bool {
+	// Walk the list of extra fields.
+	for len(extra) >= 4 {
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if binary.LittleEndian.Uint16(extra[0:]) == id {
+			return true
+		}
+		extra = extra[4+size:]
+	}
+
+	return false
+}
+
+// deviceExtra encodes the numbers of a device node as a "dv" extra field.
+func deviceExtra(major, minor int64) []byte {
+	extra := make([]byte, 12)
+	binary.LittleEndian.PutUint16(extra[0:], deviceExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 8)
+	binary.LittleEndian.PutUint32(extra[4:], uint32(major))
+	binary.LittleEndian.PutUint32(extra[8:], uint32(minor))
+	return extra
+}
+
+// parseDeviceExtra extracts the numbers of a device node from a "dv" extra
+// field, returning zero values if the field is absent.
+func parseDeviceExtra(extra []byte) (int64, int64) {
+	// Walk the list of extra fields looking for the "dv" field.
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		if id == deviceExtraID && size == 8 {
+			return int64(binary.LittleEndian.Uint32(field[0:])), int64(binary.LittleEndian.Uint32(field[4:]))
+		}
+	}
+
+	return 0, 0
+}
+
+// sparseExtra encodes the full size of a sparse file as an "sp" extra field.
+func sparseExtra(size int64) []byte {
+	extra := make([]byte, 12)
+	binary.LittleEndian.PutUint16(extra[0:], sparseExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 8)
+	binary.LittleEndian.PutUint64(extra[4:], uint64(size))
+	return extra
+}
+
+// parseSparseExtra extracts the full size of a sparse file from an "sp"
+// extra field, reporting whether the field is present.
+func parseSparseExtra(extra []byte) (int64, bool) {
+	// Walk the list of extra fields looking for the "sp" field.
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		if id == sparseExtraID && size == 8 {
+			return int64(binary.LittleEndian.Uint64(field)), true
+		}
+	}
+
+	return 0, false
+}
+
+// encodeSparseMap encodes the data segments of a sparse file as the number
+// of segments followed by the offset and length of each, all 64-bit.
+func encodeSparseMap(segments []fs.Segment) []byte {
+	data := binary.LittleEndian.AppendUint64(nil, uint64(len(segments)))
+	for _, segment := range segments {
+		data = binary.LittleEndian.AppendUint64(data, uint64(segment.Offset))
+		data = binary.LittleEndian.AppendUint64(data, uint64(segment.Length))
+	}
+	return data
+}
+
+// decodeSparseMap reads the data segments of a sparse file from the start of
+// its contents, checking that they are ordered and lie within the file.
+func decodeSparseMap(r io.Reader, size int64) ([]fs.Segment, error) {
+	// Read the number of segments.
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+
+	// Read each segment, never allocating more than the file could hold.
+	var segments []fs.Segment
+	var end int64
+	for i := uint64(0); i < count; i++ {
+		var pair [2]uint64
+		if err := binary.Read(r, binary.LittleEndian, &pair); err != nil {
+			return nil, err
+		}
+		segment := fs.Segment{Offset: int64(pair[0]), Length: int64(pair[1])}
+		if segment.Offset < end || segment.Length < 0 || segment.Offset+segment.Length > size {
+			return nil, fmt.Errorf("invalid segment at offset %d", segment.Offset)
+		}
+		segments = append(segments, segment)
+		end = segment.Offset + segment.Length
+	}
+
+	return segments, nil
+}
+
+// xattrExtra encodes extended attributes as an "xa" extra field. Each
+// attribute is stored as a 16-bit name length, the name, a 16-bit value length
+// and the value, in name order.
+func xattrExtra(xattrs map[string][]byte) ([]byte, error) {
+	// Encode the attributes in a stable order.
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var field []byte
+	for _, name := range names {
+		field = binary.LittleEndian.AppendUint16(field, uint16(len(name)))
+		field = append(field, name...)
+		field = binary.LittleEndian.AppendUint16(field, uint16(len(xattrs[name])))
+		field = append(field, xattrs[name]...)
+	}
+
+	// Extra fields are limited to 64 KiB.
+	if len(field) > math.MaxUint16-64 {
+		return nil, fmt.Errorf("extended attributes of %d bytes exceed the extra field limit", len(field))
+	}
+
+	extra := make([]byte, 4, 4+len(field))
+	binary.LittleEndian.PutUint16(extra[0:], xattrExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(field)))
+	return append(extra, field...), nil
+}
+
+// parseXattrExtra decodes the extended attributes of an "xa" extra field,
+// returning nil if the field is absent or malformed.
+func parseXattrExtra(extra []byte) map[string][]byte {
+	// Walk the list of extra fields looking for the "xa" field.
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != xattrExtraID {
+			continue
+		}
+
+		// Decode the length-prefixed names and values.
+		xattrs := make(map[string][]byte)
+		for len(field) > 0 {
+			name, rest, ok := cutLengthPrefixed(field)
+			if !ok {
+				return nil
+			}
+			value, rest, ok := cutLengthPrefixed(rest)
+			if !ok {
+				return nil
+			}
+			xattrs[string(name)] = value
+			field = rest
+		}
+		return xattrs
+	}
+
+	return nil
+}
+
+// cutLengthPrefixed splits a 16-bit length-prefixed value off the front of data.
+func cutLengthPrefixed(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+	size := int(binary.LittleEndian.Uint16(data))
+	if len(data) < 2+size {
+		return nil, nil, false
+	}
+	return append([]byte(nil), data[2:2+size]...), data[2+size:], true
+}